@@ -8,12 +8,18 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"syscall"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sathiraumesh/wireguard_exporter/internal/accounting"
+	"github.com/sathiraumesh/wireguard_exporter/internal/container"
+	"github.com/sathiraumesh/wireguard_exporter/internal/peermeta"
+	"github.com/sathiraumesh/wireguard_exporter/internal/quota"
 	"github.com/sathiraumesh/wireguard_exporter/internal/wgprometheus"
+	"github.com/sathiraumesh/wireguard_exporter/internal/wgquick"
 )
 
 var (
@@ -23,10 +29,52 @@ var (
 
 const DefaultPort = 9011
 
+// DefaultScrapeTimeout bounds a scrape when Prometheus sends no timeout.
+const DefaultScrapeTimeout = wgprometheus.DefaultScrapeTimeout
+
+// scrapeTimeoutOffset is subtracted from the Prometheus scrape timeout to
+// leave time for encoding and sending the response.
+const scrapeTimeoutOffset = 500 * time.Millisecond
+
 var port = flag.Int("p", getEnvInt("WIREGUARD_EXPORTER_PORT", DefaultPort), "the port to listen on (env: WIREGUARD_EXPORTER_PORT)")
 var interfaces = flag.String("i", getEnvStr("WIREGUARD_EXPORTER_INTERFACES", ""), "comma-separated list of interfaces (env: WIREGUARD_EXPORTER_INTERFACES)")
+var includeInterfaces = flag.String("include-interfaces", getEnvStr("WIREGUARD_EXPORTER_INCLUDE_INTERFACES", ""), "comma-separated glob patterns, or re:<regexp>, of interfaces to monitor (env: WIREGUARD_EXPORTER_INCLUDE_INTERFACES)")
+var excludeInterfaces = flag.String("exclude-interfaces", getEnvStr("WIREGUARD_EXPORTER_EXCLUDE_INTERFACES", ""), "comma-separated glob patterns, or re:<regexp>, of interfaces to skip (env: WIREGUARD_EXPORTER_EXCLUDE_INTERFACES)")
+var peerAllowlist = flag.String("peer-allowlist", getEnvStr("WIREGUARD_EXPORTER_PEER_ALLOWLIST", ""), "comma-separated public keys of the only peers to export (env: WIREGUARD_EXPORTER_PEER_ALLOWLIST)")
+var peerDenylist = flag.String("peer-denylist", getEnvStr("WIREGUARD_EXPORTER_PEER_DENYLIST", ""), "comma-separated public keys of peers never to export (env: WIREGUARD_EXPORTER_PEER_DENYLIST)")
+var peerCIDRs = flag.String("peer-cidrs", getEnvStr("WIREGUARD_EXPORTER_PEER_CIDRS", ""), "comma-separated CIDRs; only peers whose allowed IPs overlap one are exported (env: WIREGUARD_EXPORTER_PEER_CIDRS)")
+var scanNetns = flag.Bool("netns", getEnvBool("WIREGUARD_EXPORTER_NETNS", false), "also scan named network namespaces under "+wgprometheus.DefaultNetnsDir+" (env: WIREGUARD_EXPORTER_NETNS)")
+var netnsPaths = flag.String("netns-paths", getEnvStr("WIREGUARD_EXPORTER_NETNS_PATHS", ""), "comma-separated network namespace paths to scan, e.g. /proc/<pid>/ns/net (env: WIREGUARD_EXPORTER_NETNS_PATHS)")
+var containerLabels = flag.Bool("containers", getEnvBool("WIREGUARD_EXPORTER_CONTAINERS", false), "resolve scanned network namespaces to containers and add container labels (env: WIREGUARD_EXPORTER_CONTAINERS)")
+var dockerRoot = flag.String("docker-root", getEnvStr("WIREGUARD_EXPORTER_DOCKER_ROOT", container.DefaultDockerRoot), "directory of Docker container configs used to look up container names (env: WIREGUARD_EXPORTER_DOCKER_ROOT)")
+var peerMetadata = flag.String("peer-metadata", getEnvStr("WIREGUARD_EXPORTER_PEER_METADATA", ""), "path to a YAML or JSON file with peer names and labels (env: WIREGUARD_EXPORTER_PEER_METADATA)")
+var wgQuickDir = flag.String("wg-quick-dir", getEnvStr("WIREGUARD_EXPORTER_WG_QUICK_DIR", ""), "directory of wg-quick configs to read peer name comments from, e.g. /etc/wireguard (env: WIREGUARD_EXPORTER_WG_QUICK_DIR)")
+var legacyByteGauges = flag.Bool("legacy-byte-gauges", getEnvBool("WIREGUARD_EXPORTER_LEGACY_BYTE_GAUGES", true), "also emit the deprecated wireguard_transmitted_bytes and wireguard_received_bytes gauges (env: WIREGUARD_EXPORTER_LEGACY_BYTE_GAUGES)")
+var allowedIPsMode = flag.String("allowed-ips-mode", getEnvStr("WIREGUARD_EXPORTER_ALLOWED_IPS_MODE", string(wgprometheus.AllowedIPsLabel)), "how to export peer allowed IPs: label or info (env: WIREGUARD_EXPORTER_ALLOWED_IPS_MODE)")
+var neverHandshakeMode = flag.String("never-handshake-age", getEnvStr("WIREGUARD_EXPORTER_NEVER_HANDSHAKE_AGE", string(wgprometheus.NeverHandshakeOmit)), "how peers without a handshake appear in wireguard_peer_handshake_age_seconds: omit or inf (env: WIREGUARD_EXPORTER_NEVER_HANDSHAKE_AGE)")
+var peerTimeout = flag.Duration("peer-timeout", getEnvDuration("WIREGUARD_EXPORTER_PEER_TIMEOUT", wgprometheus.PeerHandshakeTimeout), "handshake age after which a peer is considered down (env: WIREGUARD_EXPORTER_PEER_TIMEOUT)")
+var interfacePeerTimeouts = flag.String("interface-peer-timeouts", getEnvStr("WIREGUARD_EXPORTER_INTERFACE_PEER_TIMEOUTS", ""), "comma-separated per-interface peer timeouts, e.g. wg0=10m,wg1=1m (env: WIREGUARD_EXPORTER_INTERFACE_PEER_TIMEOUTS)")
+var keepaliveTimeoutMultiplier = flag.Float64("keepalive-timeout-multiplier", getEnvFloat("WIREGUARD_EXPORTER_KEEPALIVE_TIMEOUT_MULTIPLIER", 0), "derive the timeout of peers with persistent keepalive as this multiple of the interval plus the rekey window, 0 disables (env: WIREGUARD_EXPORTER_KEEPALIVE_TIMEOUT_MULTIPLIER)")
+var pollInterval = flag.Duration("poll-interval", getEnvDuration("WIREGUARD_EXPORTER_POLL_INTERVAL", 0), "read devices in the background at this interval and serve scrapes from the latest snapshot, 0 disables (env: WIREGUARD_EXPORTER_POLL_INTERVAL)")
+var scrapeTimeout = flag.Duration("scrape-timeout", getEnvDuration("WIREGUARD_EXPORTER_SCRAPE_TIMEOUT", DefaultScrapeTimeout), "maximum time a scrape may spend reading devices; lowered to the Prometheus scrape timeout when shorter (env: WIREGUARD_EXPORTER_SCRAPE_TIMEOUT)")
+var workers = flag.Int("workers", getEnvInt("WIREGUARD_EXPORTER_WORKERS", wgprometheus.DefaultWorkers), "number of interfaces, and network namespaces, read concurrently (env: WIREGUARD_EXPORTER_WORKERS)")
+var maxPeersPerInterface = flag.Int("max-peers-per-interface", getEnvInt("WIREGUARD_EXPORTER_MAX_PEERS_PER_INTERFACE", 0), "maximum peers per interface with per-peer series, 0 disables (env: WIREGUARD_EXPORTER_MAX_PEERS_PER_INTERFACE)")
+var maxTotalPeers = flag.Int("max-total-peers", getEnvInt("WIREGUARD_EXPORTER_MAX_TOTAL_PEERS", 0), "maximum peers across all interfaces with per-peer series, 0 disables (env: WIREGUARD_EXPORTER_MAX_TOTAL_PEERS)")
+var counterStateFile = flag.String("counter-state-file", getEnvStr("WIREGUARD_EXPORTER_COUNTER_STATE_FILE", ""), "file keeping cumulative peer byte counters that survive interface and exporter restarts, empty disables (env: WIREGUARD_EXPORTER_COUNTER_STATE_FILE)")
+var accountingDir = flag.String("accounting-dir", getEnvStr("WIREGUARD_EXPORTER_ACCOUNTING_DIR", ""), "directory to record per-peer traffic into hourly, daily and monthly buckets, empty disables (env: WIREGUARD_EXPORTER_ACCOUNTING_DIR)")
+var quotaFile = flag.String("quota-file", getEnvStr("WIREGUARD_EXPORTER_QUOTA_FILE", ""), "YAML or JSON file of per-peer traffic quotas, requires -accounting-dir (env: WIREGUARD_EXPORTER_QUOTA_FILE)")
+var peerSessions = flag.Bool("peer-sessions", getEnvBool("WIREGUARD_EXPORTER_PEER_SESSIONS", false), "export when peers were first seen, their session starts and connected time (env: WIREGUARD_EXPORTER_PEER_SESSIONS)")
+var peerLimitMode = flag.String("peer-limit-mode", getEnvStr("WIREGUARD_EXPORTER_PEER_LIMIT_MODE", string(wgprometheus.PeerLimitDrop)), "what to do when a peer limit is exceeded: drop or top (env: WIREGUARD_EXPORTER_PEER_LIMIT_MODE)")
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "report" {
+		if err := runReport(os.Args[2:], os.Stdout); err != nil {
+			slog.Error("report failed", "error", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	flag.Parse()
 
 	addr, err := parsePort(*port)
@@ -37,18 +85,213 @@ func main() {
 
 	interfacesList := parseInterfaces(*interfaces)
 
+	includePatterns, err := parseInterfacePatterns(*includeInterfaces)
+	if err != nil {
+		slog.Error("invalid include interface patterns", "error", err)
+		os.Exit(1)
+	}
+	excludePatterns, err := parseInterfacePatterns(*excludeInterfaces)
+	if err != nil {
+		slog.Error("invalid exclude interface patterns", "error", err)
+		os.Exit(1)
+	}
+
+	if *peerTimeout <= 0 {
+		slog.Error("invalid peer timeout, must be positive", "timeout", *peerTimeout)
+		os.Exit(1)
+	}
+	if *scrapeTimeout <= 0 {
+		slog.Error("invalid scrape timeout, must be positive", "timeout", *scrapeTimeout)
+		os.Exit(1)
+	}
+	if *workers < 1 {
+		slog.Error("invalid worker count, must be at least 1", "workers", *workers)
+		os.Exit(1)
+	}
+	if *maxPeersPerInterface < 0 || *maxTotalPeers < 0 {
+		slog.Error("invalid peer limit, must not be negative", "per_interface", *maxPeersPerInterface, "total", *maxTotalPeers)
+		os.Exit(1)
+	}
+	limitMode, err := parsePeerLimitMode(*peerLimitMode)
+	if err != nil {
+		slog.Error("invalid peer limit mode", "error", err)
+		os.Exit(1)
+	}
+	if *pollInterval < 0 {
+		slog.Error("invalid poll interval, must not be negative", "interval", *pollInterval)
+		os.Exit(1)
+	}
+	if *keepaliveTimeoutMultiplier < 0 {
+		slog.Error("invalid keepalive timeout multiplier, must not be negative", "multiplier", *keepaliveTimeoutMultiplier)
+		os.Exit(1)
+	}
+	allowKeys, err := parsePublicKeys(*peerAllowlist)
+	if err != nil {
+		slog.Error("invalid peer allowlist", "error", err)
+		os.Exit(1)
+	}
+	denyKeys, err := parsePublicKeys(*peerDenylist)
+	if err != nil {
+		slog.Error("invalid peer denylist", "error", err)
+		os.Exit(1)
+	}
+	cidrs, err := parseCIDRs(*peerCIDRs)
+	if err != nil {
+		slog.Error("invalid peer CIDRs", "error", err)
+		os.Exit(1)
+	}
+
+	timeouts, err := parseInterfaceTimeouts(*interfacePeerTimeouts)
+	if err != nil {
+		slog.Error("invalid interface peer timeouts", "error", err)
+		os.Exit(1)
+	}
+
+	ipsMode, err := parseAllowedIPsMode(*allowedIPsMode)
+	if err != nil {
+		slog.Error("invalid allowed IPs mode", "error", err)
+		os.Exit(1)
+	}
+
+	handshakeMode, err := parseNeverHandshakeMode(*neverHandshakeMode)
+	if err != nil {
+		slog.Error("invalid never-handshake age mode", "error", err)
+		os.Exit(1)
+	}
+
+	opts := []wgprometheus.Option{
+		wgprometheus.WithInterfaceInclude(includePatterns),
+		wgprometheus.WithInterfaceExclude(excludePatterns),
+		wgprometheus.WithPeerAllowlist(allowKeys),
+		wgprometheus.WithPeerDenylist(denyKeys),
+		wgprometheus.WithPeerCIDRs(cidrs),
+		wgprometheus.WithLegacyByteGauges(*legacyByteGauges),
+		wgprometheus.WithAllowedIPsMode(ipsMode),
+		wgprometheus.WithNeverHandshakeMode(handshakeMode),
+		wgprometheus.WithPeerTimeout(*peerTimeout),
+		wgprometheus.WithInterfacePeerTimeouts(timeouts),
+		wgprometheus.WithKeepaliveTimeout(*keepaliveTimeoutMultiplier),
+		wgprometheus.WithWorkers(*workers),
+		wgprometheus.WithScrapeTimeout(*scrapeTimeout),
+		wgprometheus.WithMaxPeersPerInterface(*maxPeersPerInterface),
+		wgprometheus.WithMaxTotalPeers(*maxTotalPeers),
+		wgprometheus.WithPeerLimitMode(limitMode),
+	}
+	if *scanNetns || *netnsPaths != "" || *containerLabels {
+		dir := ""
+		if *scanNetns {
+			dir = wgprometheus.DefaultNetnsDir
+		}
+		lister := wgprometheus.NewNetnsLister(dir, parseList(*netnsPaths))
+		lister.Workers = *workers
+		if *containerLabels {
+			lister.Containers = container.NewResolver(container.DefaultProcRoot, *dockerRoot)
+			opts = append(opts, wgprometheus.WithContainerLabels())
+		}
+		opts = append(opts, wgprometheus.WithNamespaceLister(lister))
+	}
+	if *peerMetadata != "" {
+		src, err := peermeta.NewSource(*peerMetadata)
+		if err != nil {
+			slog.Error("invalid peer metadata file", "error", err)
+			os.Exit(1)
+		}
+		opts = append(opts, wgprometheus.WithPeerMetadata(src))
+	}
+	if *wgQuickDir != "" {
+		opts = append(opts, wgprometheus.WithWGQuickNames(wgquick.NewDir(*wgQuickDir)))
+	}
+	if *quotaFile != "" && *accountingDir == "" {
+		slog.Error("-quota-file requires -accounting-dir")
+		os.Exit(1)
+	}
+	if *quotaFile != "" {
+		src, err := quota.NewSource(*quotaFile)
+		if err != nil {
+			slog.Error("invalid quota file", "error", err)
+			os.Exit(1)
+		}
+		if src.Config().HasGroups() && *peerMetadata == "" {
+			slog.Error("quotas for peer labels require -peer-metadata", "path", *quotaFile)
+			os.Exit(1)
+		}
+		opts = append(opts, wgprometheus.WithQuotas(src))
+	}
+	var accountingState *wgprometheus.CounterState
+	if *accountingDir != "" {
+		statePath := filepath.Join(*accountingDir, "counters.json")
+		if *counterStateFile != "" && filepath.Clean(*counterStateFile) == statePath {
+			slog.Error("-counter-state-file must not be the counter state of -accounting-dir", "path", statePath)
+			os.Exit(1)
+		}
+		accountingState, err = wgprometheus.LoadCounterState(statePath)
+		if err != nil {
+			slog.Error("invalid accounting counter state", "error", err)
+			os.Exit(1)
+		}
+	}
+	if *counterStateFile != "" {
+		state, err := wgprometheus.LoadCounterState(*counterStateFile)
+		if err != nil {
+			slog.Error("invalid counter state file", "error", err)
+			os.Exit(1)
+		}
+		opts = append(opts, wgprometheus.WithCumulativeCounters(state))
+	}
+	if *peerSessions {
+		opts = append(opts, wgprometheus.WithPeerSessions())
+	}
+
+	// The accounting store is opened last: once it is open, failures must
+	// go through run so that it is closed.
+	var store *accounting.Store
+	if *accountingDir != "" {
+		store, err = accounting.Open(*accountingDir)
+		if err != nil {
+			slog.Error("invalid accounting directory", "error", err)
+			os.Exit(1)
+		}
+		opts = append(opts, wgprometheus.WithAccounting(store, accountingState))
+	}
+
 	slog.Info("starting wireguard exporter",
 		"address", addr,
 		"version", version,
 		"commit", commit,
 	)
+	if err := run(addr, wgprometheus.NewCollector(interfacesList, opts...), store); err != nil {
+		slog.Error("server failed", "error", err)
+		os.Exit(1)
+	}
+	slog.Info("server stopped")
+}
 
-	collector := wgprometheus.NewCollector(interfacesList)
-	registry := prometheus.NewRegistry()
-	registry.MustRegister(collector)
+// run serves collector on addr until SIGINT or SIGTERM, then closes the
+// collector and the accounting store, if any, so that their state is
+// saved whether or not serving failed.
+func run(addr string, collector *wgprometheus.Collector, store *accounting.Store) error {
+	if store != nil {
+		defer func() {
+			if err := store.Close(); err != nil {
+				slog.Error("failed to write traffic accounting", "error", err)
+			}
+		}()
+	}
+	defer func() {
+		if err := collector.Close(); err != nil {
+			slog.Error("failed to close collector", "error", err)
+		}
+	}()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if *pollInterval > 0 {
+		collector.StartPolling(ctx, *pollInterval)
+	}
 
 	mux := http.NewServeMux()
-	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	mux.Handle("/metrics", metricsHandler(collector, *scrapeTimeout))
 	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		fmt.Fprintln(w, "ok")
@@ -58,30 +301,42 @@ func main() {
 		Addr:         addr,
 		Handler:      mux,
 		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 10 * time.Second,
+		WriteTimeout: *scrapeTimeout + 5*time.Second,
 		IdleTimeout:  60 * time.Second,
 	}
 
-	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
-	defer stop()
-
+	served := make(chan error, 1)
 	go func() {
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			slog.Error("server failed", "error", err)
-			os.Exit(1)
-		}
+		served <- server.ListenAndServe()
 	}()
 
-	<-ctx.Done()
+	select {
+	case err := <-served:
+		return err
+	case <-ctx.Done():
+	}
 	slog.Info("shutting down server")
 
 	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
 	if err := server.Shutdown(shutdownCtx); err != nil {
-		slog.Error("server shutdown failed", "error", err)
-		os.Exit(1)
+		return fmt.Errorf("shutting down: %w", err)
 	}
+	return nil
+}
 
-	slog.Info("server stopped")
+// metricsHandler serves the collector with a deadline derived from the
+// Prometheus scrape timeout, so that a hung WireGuard read yields partial
+// metrics instead of a write timeout.
+func metricsHandler(collector *wgprometheus.Collector, limit time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		timeout := parseScrapeTimeout(r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds"), limit)
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(collector.WithContext(ctx))
+		promhttp.HandlerFor(registry, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+	})
 }
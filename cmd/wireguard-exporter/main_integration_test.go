@@ -53,6 +53,8 @@ func TestMetricsEndpointIntegration(t *testing.T) {
 	assert.Contains(t, responseText, "wireguard_latest_handshake_seconds")
 	assert.Contains(t, responseText, "wireguard_transmitted_bytes")
 	assert.Contains(t, responseText, "wireguard_received_bytes")
+	assert.Contains(t, responseText, "wireguard_peer_transmit_bytes_total")
+	assert.Contains(t, responseText, "wireguard_peer_receive_bytes_total")
 	assert.Contains(t, responseText, "wireguard_peer_up")
 	assert.Contains(t, responseText, "wireguard_interface_info")
 	assert.Contains(t, responseText, "wireguard_scrape_success")
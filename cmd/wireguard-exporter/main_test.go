@@ -72,6 +72,8 @@ func TestMetricsEndpoint(t *testing.T) {
 	assert.Contains(t, responseText, "wireguard_latest_handshake_seconds")
 	assert.Contains(t, responseText, "wireguard_transmitted_bytes")
 	assert.Contains(t, responseText, "wireguard_received_bytes")
+	assert.Contains(t, responseText, "wireguard_peer_transmit_bytes_total")
+	assert.Contains(t, responseText, "wireguard_peer_receive_bytes_total")
 	assert.Contains(t, responseText, "wireguard_peer_up")
 	assert.Contains(t, responseText, "wireguard_interface_info")
 	assert.Contains(t, responseText, "wireguard_scrape_success")
@@ -79,6 +81,42 @@ func TestMetricsEndpoint(t *testing.T) {
 	assert.Contains(t, responseText, `interface="wg0"`)
 }
 
+// blockingDeviceLister never returns, like a wedged netlink socket.
+type blockingDeviceLister struct{}
+
+func (blockingDeviceLister) Devices() ([]*wgtypes.Device, error) {
+	select {}
+}
+
+func TestMetricsEndpointScrapeTimeout(t *testing.T) {
+	collector := wgprometheus.NewCollectorWithDevices(nil, blockingDeviceLister{})
+	testServer := httptest.NewServer(metricsHandler(collector, time.Minute))
+	defer testServer.Close()
+
+	req, err := http.NewRequest(http.MethodGet, testServer.URL+"/metrics", nil)
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	req.Header.Set("X-Prometheus-Scrape-Timeout-Seconds", "0.6")
+
+	start := time.Now()
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to make GET request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	assert.Less(t, time.Since(start), 5*time.Second)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Failed to read response body: %v", err)
+	}
+	assert.Contains(t, string(body), "wireguard_scrape_timed_out 1")
+	assert.Contains(t, string(body), "wireguard_scrape_success 0")
+}
+
 func TestHealthEndpoint(t *testing.T) {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/sathiraumesh/wireguard_exporter/internal/accounting"
+	"github.com/sathiraumesh/wireguard_exporter/internal/peermeta"
+)
+
+// reportRow is a row of a traffic report as written to CSV and JSON.
+type reportRow struct {
+	Start     string `json:"start"`
+	Namespace string `json:"namespace"`
+	Interface string `json:"interface"`
+	PublicKey string `json:"public_key,omitempty"`
+	Name      string `json:"name,omitempty"`
+	Transmit  int64  `json:"transmit_bytes"`
+	Receive   int64  `json:"receive_bytes"`
+	Total     int64  `json:"total_bytes"`
+}
+
+type reportFile struct {
+	From   string      `json:"from"`
+	To     string      `json:"to"`
+	Period string      `json:"period"`
+	Rows   []reportRow `json:"rows"`
+}
+
+// runReport implements the report subcommand, which sums the recorded
+// traffic of a date range per peer or interface.
+func runReport(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("report", flag.ExitOnError)
+	dir := fs.String("accounting-dir", getEnvStr("WIREGUARD_EXPORTER_ACCOUNTING_DIR", ""), "directory of the traffic accounting buckets (env: WIREGUARD_EXPORTER_ACCOUNTING_DIR)")
+	from := fs.String("from", "", "first day of the report, YYYY-MM-DD (default: first day of the current month)")
+	to := fs.String("to", "", "last day of the report, YYYY-MM-DD (default: today)")
+	periodArg := fs.String("period", string(accounting.PeriodTotal), "break the range down by total, hour, day or month")
+	groupByArg := fs.String("group-by", "peer", "sum traffic per peer or interface")
+	formatArg := fs.String("format", "csv", "output format: csv or json")
+	output := fs.String("o", "", "file to write the report to (default: standard output)")
+	metadataPath := fs.String("peer-metadata", getEnvStr("WIREGUARD_EXPORTER_PEER_METADATA", ""), "YAML or JSON file to name peers from (env: WIREGUARD_EXPORTER_PEER_METADATA)")
+	fs.Parse(args)
+
+	if *dir == "" {
+		return fmt.Errorf("-accounting-dir is required")
+	}
+	start, end, err := parseDateRange(*from, *to, time.Now())
+	if err != nil {
+		return err
+	}
+	period, err := accounting.ParsePeriod(*periodArg)
+	if err != nil {
+		return err
+	}
+	byInterface, err := parseGroupBy(*groupByArg)
+	if err != nil {
+		return err
+	}
+	format, err := parseReportFormat(*formatArg)
+	if err != nil {
+		return err
+	}
+	var meta *peermeta.Metadata
+	if *metadataPath != "" {
+		src, err := peermeta.NewSource(*metadataPath)
+		if err != nil {
+			return err
+		}
+		meta = src.Metadata()
+	}
+
+	store, err := accounting.OpenReadOnly(*dir)
+	if err != nil {
+		return err
+	}
+	rows, err := store.Report(start, end, period, byInterface)
+	if err != nil {
+		return err
+	}
+
+	report := reportFile{
+		From:   start.Format(time.DateOnly),
+		To:     end.AddDate(0, 0, -1).Format(time.DateOnly),
+		Period: string(period),
+		Rows:   make([]reportRow, 0, len(rows)),
+	}
+	for _, r := range rows {
+		row := reportRow{
+			Start:     r.Start.Format(time.RFC3339),
+			Namespace: r.Namespace,
+			Interface: r.Interface,
+			PublicKey: r.PublicKey,
+			Transmit:  r.Transmit,
+			Receive:   r.Receive,
+			Total:     r.Total(),
+		}
+		if meta != nil && r.PublicKey != "" {
+			if peer, ok := meta.Lookup(r.PublicKey); ok {
+				row.Name = peer.Name
+			}
+		}
+		report.Rows = append(report.Rows, row)
+	}
+
+	if *output == "" {
+		return writeReport(stdout, format, report)
+	}
+	f, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+	if err := writeReport(f, format, report); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func writeReport(w io.Writer, format string, report reportFile) error {
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	}
+	return writeReportCSV(w, report.Rows)
+}
+
+func writeReportCSV(w io.Writer, rows []reportRow) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"start", "namespace", "interface", "public_key", "name", "transmit_bytes", "receive_bytes", "total_bytes"})
+	for _, r := range rows {
+		cw.Write([]string{
+			r.Start, r.Namespace, r.Interface, r.PublicKey, r.Name,
+			strconv.FormatInt(r.Transmit, 10),
+			strconv.FormatInt(r.Receive, 10),
+			strconv.FormatInt(r.Total, 10),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sathiraumesh/wireguard_exporter/internal/accounting"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeTestAccounting(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	store, err := accounting.Open(dir)
+	require.NoError(t, err)
+	at := time.Date(2026, 9, 10, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, store.Record(at, map[accounting.Peer]accounting.Usage{
+		{Interface: "wg0", PublicKey: "alice"}: {Transmit: 100, Receive: 50},
+		{Interface: "wg0", PublicKey: "bob"}:   {Transmit: 1, Receive: 2},
+	}))
+	require.NoError(t, store.Close())
+	return dir
+}
+
+func TestRunReportCSV(t *testing.T) {
+	dir := writeTestAccounting(t)
+	metadata := filepath.Join(t.TempDir(), "peers.yaml")
+	require.NoError(t, os.WriteFile(metadata, []byte("peers:\n  alice:\n    name: alice-laptop\n"), 0o600))
+
+	var out bytes.Buffer
+	err := runReport([]string{"-accounting-dir", dir, "-from", "2026-09-01", "-to", "2026-09-30", "-peer-metadata", metadata}, &out)
+	require.NoError(t, err)
+	assert.Equal(t, `start,namespace,interface,public_key,name,transmit_bytes,receive_bytes,total_bytes
+2026-09-01T00:00:00Z,,wg0,alice,alice-laptop,100,50,150
+2026-09-01T00:00:00Z,,wg0,bob,,1,2,3
+`, out.String())
+}
+
+func TestRunReportJSONByInterface(t *testing.T) {
+	dir := writeTestAccounting(t)
+	output := filepath.Join(t.TempDir(), "report.json")
+
+	err := runReport([]string{"-accounting-dir", dir, "-from", "2026-09-10", "-to", "2026-09-10", "-period", "day", "-group-by", "interface", "-format", "json", "-o", output}, nil)
+	require.NoError(t, err)
+
+	data, err := os.ReadFile(output)
+	require.NoError(t, err)
+	var report reportFile
+	require.NoError(t, json.Unmarshal(data, &report))
+	assert.Equal(t, reportFile{
+		From:   "2026-09-10",
+		To:     "2026-09-10",
+		Period: "day",
+		Rows: []reportRow{
+			{Start: "2026-09-10T00:00:00Z", Interface: "wg0", Transmit: 101, Receive: 52, Total: 153},
+		},
+	}, report)
+}
+
+func TestRunReportErrors(t *testing.T) {
+	dir := writeTestAccounting(t)
+
+	assert.EqualError(t, runReport(nil, nil), "-accounting-dir is required")
+	missing := filepath.Join(dir, "missing")
+	assert.ErrorIs(t, runReport([]string{"-accounting-dir", missing}, nil), os.ErrNotExist)
+	assert.NoDirExists(t, missing)
+	assert.EqualError(t, runReport([]string{"-accounting-dir", dir, "-format", "xml"}, nil), `report format must be "csv" or "json", got "xml"`)
+}
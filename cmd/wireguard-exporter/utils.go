@@ -3,9 +3,14 @@ package main
 import (
 	"fmt"
 	"log/slog"
+	"net/netip"
 	"os"
 	"strconv"
 	"strings"
+	"time"
+
+	"github.com/sathiraumesh/wireguard_exporter/internal/wgprometheus"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
 )
 
 const (
@@ -33,6 +38,185 @@ func parseInterfaces(interfaceArg string) []string {
 	return strings.Split(interfaceArg, ",")
 }
 
+func parseList(arg string) []string {
+	var items []string
+	for _, item := range strings.Split(arg, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func parseInterfacePatterns(arg string) ([]wgprometheus.NamePattern, error) {
+	arg = strings.TrimSpace(arg)
+	if arg == "" {
+		return nil, nil
+	}
+
+	var patterns []wgprometheus.NamePattern
+	for _, raw := range strings.Split(arg, ",") {
+		p, err := wgprometheus.ParseNamePattern(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid interface pattern %q: %w", raw, err)
+		}
+		patterns = append(patterns, p)
+	}
+	return patterns, nil
+}
+
+func parsePublicKeys(arg string) ([]wgtypes.Key, error) {
+	arg = strings.TrimSpace(arg)
+	if arg == "" {
+		return nil, nil
+	}
+
+	var keys []wgtypes.Key
+	for _, raw := range strings.Split(arg, ",") {
+		key, err := wgtypes.ParseKey(strings.TrimSpace(raw))
+		if err != nil {
+			return nil, fmt.Errorf("invalid public key %q: %w", raw, err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func parseCIDRs(arg string) ([]netip.Prefix, error) {
+	arg = strings.TrimSpace(arg)
+	if arg == "" {
+		return nil, nil
+	}
+
+	var prefixes []netip.Prefix
+	for _, raw := range strings.Split(arg, ",") {
+		prefix, err := netip.ParsePrefix(strings.TrimSpace(raw))
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q: %w", raw, err)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+func parseInterfaceTimeouts(arg string) (map[string]time.Duration, error) {
+	arg = strings.TrimSpace(arg)
+	if arg == "" {
+		return nil, nil
+	}
+
+	timeouts := make(map[string]time.Duration)
+	for _, entry := range strings.Split(arg, ",") {
+		name, value, ok := strings.Cut(entry, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid interface timeout %q, expected <interface>=<duration>", entry)
+		}
+		d, err := time.ParseDuration(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("invalid timeout for interface %s: %w", name, err)
+		}
+		if d <= 0 {
+			return nil, fmt.Errorf("timeout for interface %s must be positive, got %s", name, d)
+		}
+		timeouts[name] = d
+	}
+	return timeouts, nil
+}
+
+func parseAllowedIPsMode(arg string) (wgprometheus.AllowedIPsMode, error) {
+	switch mode := wgprometheus.AllowedIPsMode(strings.TrimSpace(arg)); mode {
+	case wgprometheus.AllowedIPsLabel, wgprometheus.AllowedIPsInfo:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("allowed IPs mode must be %q or %q, got %q",
+			wgprometheus.AllowedIPsLabel, wgprometheus.AllowedIPsInfo, arg)
+	}
+}
+
+func parseNeverHandshakeMode(arg string) (wgprometheus.NeverHandshakeMode, error) {
+	switch mode := wgprometheus.NeverHandshakeMode(strings.TrimSpace(arg)); mode {
+	case wgprometheus.NeverHandshakeOmit, wgprometheus.NeverHandshakeInf:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("never-handshake mode must be %q or %q, got %q",
+			wgprometheus.NeverHandshakeOmit, wgprometheus.NeverHandshakeInf, arg)
+	}
+}
+
+// parseScrapeTimeout returns the time a scrape may spend reading devices,
+// given Prometheus' X-Prometheus-Scrape-Timeout-Seconds header. The header
+// value is reduced by scrapeTimeoutOffset to leave time for writing the
+// response, and capped at limit. A missing or invalid header yields limit.
+func parseScrapeTimeout(header string, limit time.Duration) time.Duration {
+	seconds, err := strconv.ParseFloat(strings.TrimSpace(header), 64)
+	if err != nil || seconds <= 0 {
+		return limit
+	}
+
+	timeout := time.Duration(seconds * float64(time.Second))
+	if timeout > scrapeTimeoutOffset {
+		timeout -= scrapeTimeoutOffset
+	}
+	return min(timeout, limit)
+}
+
+func parsePeerLimitMode(arg string) (wgprometheus.PeerLimitMode, error) {
+	switch mode := wgprometheus.PeerLimitMode(strings.TrimSpace(arg)); mode {
+	case wgprometheus.PeerLimitDrop, wgprometheus.PeerLimitTop:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("peer limit mode must be %q or %q, got %q",
+			wgprometheus.PeerLimitDrop, wgprometheus.PeerLimitTop, arg)
+	}
+}
+
+// parseDateRange parses inclusive YYYY-MM-DD dates into a half-open UTC
+// time range. An empty from defaults to the first day of the month of now,
+// an empty to to the day of now.
+func parseDateRange(from, to string, now time.Time) (time.Time, time.Time, error) {
+	now = now.UTC()
+	start := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	var err error
+	if from != "" {
+		if start, err = time.Parse(time.DateOnly, from); err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid from date %q, want YYYY-MM-DD", from)
+		}
+	}
+	if to != "" {
+		if end, err = time.Parse(time.DateOnly, to); err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid to date %q, want YYYY-MM-DD", to)
+		}
+	}
+	if end.Before(start) {
+		return time.Time{}, time.Time{}, fmt.Errorf("to date %s is before from date %s", end.Format(time.DateOnly), start.Format(time.DateOnly))
+	}
+	return start, end.AddDate(0, 0, 1), nil
+}
+
+func parseReportFormat(arg string) (string, error) {
+	switch format := strings.TrimSpace(arg); format {
+	case "csv", "json":
+		return format, nil
+	default:
+		return "", fmt.Errorf("report format must be \"csv\" or \"json\", got %q", arg)
+	}
+}
+
+// parseGroupBy reports whether a report is grouped by interface rather
+// than by peer.
+func parseGroupBy(arg string) (bool, error) {
+	switch strings.TrimSpace(arg) {
+	case "peer":
+		return false, nil
+	case "interface":
+		return true, nil
+	default:
+		return false, fmt.Errorf("group by must be \"peer\" or \"interface\", got %q", arg)
+	}
+}
+
 func getEnvStr(key, fallback string) string {
 	if v, ok := os.LookupEnv(key); ok {
 		return v
@@ -52,3 +236,42 @@ func getEnvInt(key string, fallback int) int {
 	}
 	return i
 }
+
+func getEnvBool(key string, fallback bool) bool {
+	v, ok := os.LookupEnv(key)
+	if !ok {
+		return fallback
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		slog.Warn("invalid environment variable, using default", "key", key, "value", v, "default", fallback)
+		return fallback
+	}
+	return b
+}
+
+func getEnvFloat(key string, fallback float64) float64 {
+	v, ok := os.LookupEnv(key)
+	if !ok {
+		return fallback
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		slog.Warn("invalid environment variable, using default", "key", key, "value", v, "default", fallback)
+		return fallback
+	}
+	return f
+}
+
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	v, ok := os.LookupEnv(key)
+	if !ok {
+		return fallback
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		slog.Warn("invalid environment variable, using default", "key", key, "value", v, "default", fallback)
+		return fallback
+	}
+	return d
+}
@@ -1,10 +1,13 @@
 package main
 
 import (
+	"net/netip"
 	"os"
 	"strconv"
 	"testing"
+	"time"
 
+	"github.com/sathiraumesh/wireguard_exporter/internal/wgprometheus"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -67,6 +70,158 @@ func TestInvalidPorts(t *testing.T) {
 	}
 }
 
+func TestParseList(t *testing.T) {
+	assert.Equal(t, []string{"/proc/1/ns/net", "/proc/2/ns/net"}, parseList(" /proc/1/ns/net,, /proc/2/ns/net "))
+	assert.Nil(t, parseList(""))
+}
+
+func TestParseInterfacePatterns(t *testing.T) {
+	patterns, err := parseInterfacePatterns(`wg-site-*, re:wg-client-\d+`)
+	assert.NoError(t, err)
+	if assert.Len(t, patterns, 2) {
+		assert.Equal(t, "wg-site-*", patterns[0].String())
+		assert.True(t, patterns[1].Match("wg-client-1234"))
+	}
+
+	patterns, err = parseInterfacePatterns(" ")
+	assert.NoError(t, err)
+	assert.Nil(t, patterns)
+
+	_, err = parseInterfacePatterns("wg0,re:wg(")
+	assert.EqualError(t, err, "invalid interface pattern \"re:wg(\": error parsing regexp: missing closing ): `^(?:wg()$`")
+
+	_, err = parseInterfacePatterns("wg0,,wg1")
+	assert.EqualError(t, err, `invalid interface pattern "": empty pattern`)
+}
+
+func TestParsePublicKeys(t *testing.T) {
+	keys, err := parsePublicKeys("HYf+yNzgj3uhARFlNy3Pawuk/yLC+WYoY2qwjjlSxxI=, YexUX3CRfPHSt7DYKV5gnRJWd8hDNkE2QxHIMZa5eEg=")
+	assert.NoError(t, err)
+	if assert.Len(t, keys, 2) {
+		assert.Equal(t, "HYf+yNzgj3uhARFlNy3Pawuk/yLC+WYoY2qwjjlSxxI=", keys[0].String())
+	}
+
+	keys, err = parsePublicKeys("")
+	assert.NoError(t, err)
+	assert.Nil(t, keys)
+
+	_, err = parsePublicKeys("not-a-key")
+	assert.ErrorContains(t, err, `invalid public key "not-a-key"`)
+}
+
+func TestParseCIDRs(t *testing.T) {
+	prefixes, err := parseCIDRs("10.8.0.7/24, fd00::/64")
+	assert.NoError(t, err)
+	assert.Equal(t, []netip.Prefix{
+		netip.MustParsePrefix("10.8.0.0/24"),
+		netip.MustParsePrefix("fd00::/64"),
+	}, prefixes)
+
+	_, err = parseCIDRs("10.8.0.0")
+	assert.ErrorContains(t, err, `invalid CIDR "10.8.0.0"`)
+}
+
+func TestParseInterfaceTimeouts(t *testing.T) {
+	timeouts, err := parseInterfaceTimeouts(" wg0=10m, mobile0 = 1h ")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]time.Duration{"wg0": 10 * time.Minute, "mobile0": time.Hour}, timeouts)
+
+	timeouts, err = parseInterfaceTimeouts("")
+	assert.NoError(t, err)
+	assert.Nil(t, timeouts)
+
+	tests := []struct {
+		name   string
+		input  string
+		errMsg string
+	}{
+		{
+			name:   "missing separator",
+			input:  "wg0",
+			errMsg: `invalid interface timeout "wg0", expected <interface>=<duration>`,
+		},
+		{
+			name:   "invalid duration",
+			input:  "wg0=soon",
+			errMsg: `invalid timeout for interface wg0: time: invalid duration "soon"`,
+		},
+		{
+			name:   "non-positive duration",
+			input:  "wg0=0s",
+			errMsg: "timeout for interface wg0 must be positive, got 0s",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseInterfaceTimeouts(tt.input)
+			assert.EqualError(t, err, tt.errMsg)
+		})
+	}
+}
+
+func TestParseAllowedIPsMode(t *testing.T) {
+	mode, err := parseAllowedIPsMode("label")
+	assert.NoError(t, err)
+	assert.Equal(t, wgprometheus.AllowedIPsLabel, mode)
+
+	mode, err = parseAllowedIPsMode(" info ")
+	assert.NoError(t, err)
+	assert.Equal(t, wgprometheus.AllowedIPsInfo, mode)
+
+	_, err = parseAllowedIPsMode("both")
+	assert.EqualError(t, err, `allowed IPs mode must be "label" or "info", got "both"`)
+}
+
+func TestParseNeverHandshakeMode(t *testing.T) {
+	mode, err := parseNeverHandshakeMode("omit")
+	assert.NoError(t, err)
+	assert.Equal(t, wgprometheus.NeverHandshakeOmit, mode)
+
+	mode, err = parseNeverHandshakeMode("inf")
+	assert.NoError(t, err)
+	assert.Equal(t, wgprometheus.NeverHandshakeInf, mode)
+
+	_, err = parseNeverHandshakeMode("zero")
+	assert.EqualError(t, err, `never-handshake mode must be "omit" or "inf", got "zero"`)
+}
+
+func TestParsePeerLimitMode(t *testing.T) {
+	mode, err := parsePeerLimitMode("drop")
+	assert.NoError(t, err)
+	assert.Equal(t, wgprometheus.PeerLimitDrop, mode)
+
+	mode, err = parsePeerLimitMode("top")
+	assert.NoError(t, err)
+	assert.Equal(t, wgprometheus.PeerLimitTop, mode)
+
+	_, err = parsePeerLimitMode("sample")
+	assert.EqualError(t, err, `peer limit mode must be "drop" or "top", got "sample"`)
+}
+
+func TestParseScrapeTimeout(t *testing.T) {
+	limit := 10 * time.Second
+	tests := []struct {
+		header string
+		want   time.Duration
+	}{
+		{"", limit},
+		{"abc", limit},
+		{"0", limit},
+		{"-3", limit},
+		{"5", 4500 * time.Millisecond},
+		{" 2.5 ", 2 * time.Second},
+		{"0.2", 200 * time.Millisecond},
+		{"30", limit},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			assert.Equal(t, tt.want, parseScrapeTimeout(tt.header, limit))
+		})
+	}
+}
+
 func TestGetEnvStr(t *testing.T) {
 	t.Run("returns env value when set", func(t *testing.T) {
 		t.Setenv("TEST_STR_VAR", "hello")
@@ -95,3 +250,104 @@ func TestGetEnvInt(t *testing.T) {
 		assert.Equal(t, 9011, getEnvInt("TEST_INT_VAR_BAD", 9011))
 	})
 }
+
+func TestGetEnvBool(t *testing.T) {
+	t.Run("returns env value when set", func(t *testing.T) {
+		t.Setenv("TEST_BOOL_VAR", "false")
+		assert.Equal(t, false, getEnvBool("TEST_BOOL_VAR", true))
+	})
+
+	t.Run("returns fallback when unset", func(t *testing.T) {
+		os.Unsetenv("TEST_BOOL_VAR_MISSING")
+		assert.Equal(t, true, getEnvBool("TEST_BOOL_VAR_MISSING", true))
+	})
+
+	t.Run("returns fallback for invalid value", func(t *testing.T) {
+		t.Setenv("TEST_BOOL_VAR_BAD", "notabool")
+		assert.Equal(t, true, getEnvBool("TEST_BOOL_VAR_BAD", true))
+	})
+}
+
+func TestGetEnvFloat(t *testing.T) {
+	t.Run("returns env value when set", func(t *testing.T) {
+		t.Setenv("TEST_FLOAT_VAR", "2.5")
+		assert.Equal(t, 2.5, getEnvFloat("TEST_FLOAT_VAR", 0))
+	})
+
+	t.Run("returns fallback for invalid value", func(t *testing.T) {
+		t.Setenv("TEST_FLOAT_VAR_BAD", "notanumber")
+		assert.Equal(t, 1.5, getEnvFloat("TEST_FLOAT_VAR_BAD", 1.5))
+	})
+}
+
+func TestGetEnvDuration(t *testing.T) {
+	t.Run("returns env value when set", func(t *testing.T) {
+		t.Setenv("TEST_DURATION_VAR", "90s")
+		assert.Equal(t, 90*time.Second, getEnvDuration("TEST_DURATION_VAR", time.Minute))
+	})
+
+	t.Run("returns fallback when unset", func(t *testing.T) {
+		os.Unsetenv("TEST_DURATION_VAR_MISSING")
+		assert.Equal(t, time.Minute, getEnvDuration("TEST_DURATION_VAR_MISSING", time.Minute))
+	})
+
+	t.Run("returns fallback for invalid value", func(t *testing.T) {
+		t.Setenv("TEST_DURATION_VAR_BAD", "soon")
+		assert.Equal(t, time.Minute, getEnvDuration("TEST_DURATION_VAR_BAD", time.Minute))
+	})
+}
+
+func TestParseDateRange(t *testing.T) {
+	now := time.Date(2026, 10, 17, 13, 0, 0, 0, time.UTC)
+	day := func(m time.Month, d int) time.Time { return time.Date(2026, m, d, 0, 0, 0, 0, time.UTC) }
+
+	tests := []struct {
+		name     string
+		from, to string
+		start    time.Time
+		end      time.Time
+		wantErr  string
+	}{
+		{name: "defaults to this month", start: day(10, 1), end: day(10, 18)},
+		{name: "explicit", from: "2026-09-01", to: "2026-09-30", start: day(9, 1), end: day(10, 1)},
+		{name: "single day", from: "2026-09-05", to: "2026-09-05", start: day(9, 5), end: day(9, 6)},
+		{name: "invalid from", from: "09/01/2026", wantErr: `invalid from date "09/01/2026", want YYYY-MM-DD`},
+		{name: "invalid to", to: "2026-13-01", wantErr: `invalid to date "2026-13-01", want YYYY-MM-DD`},
+		{name: "reversed", from: "2026-10-10", to: "2026-10-01", wantErr: "to date 2026-10-01 is before from date 2026-10-10"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, end, err := parseDateRange(tt.from, tt.to, now)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.start, start)
+			assert.Equal(t, tt.end, end)
+		})
+	}
+}
+
+func TestParseReportFormat(t *testing.T) {
+	format, err := parseReportFormat("json")
+	assert.NoError(t, err)
+	assert.Equal(t, "json", format)
+
+	_, err = parseReportFormat("xlsx")
+	assert.EqualError(t, err, `report format must be "csv" or "json", got "xlsx"`)
+}
+
+func TestParseGroupBy(t *testing.T) {
+	byInterface, err := parseGroupBy("interface")
+	assert.NoError(t, err)
+	assert.True(t, byInterface)
+
+	byInterface, err = parseGroupBy("peer")
+	assert.NoError(t, err)
+	assert.False(t, byInterface)
+
+	_, err = parseGroupBy("namespace")
+	assert.EqualError(t, err, `group by must be "peer" or "interface", got "namespace"`)
+}
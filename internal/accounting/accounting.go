@@ -0,0 +1,410 @@
+// Package accounting records per-peer traffic into hourly, daily and
+// monthly buckets on disk and sums them into reports.
+//
+// Buckets are aligned to UTC. Each granularity lives in its own directory,
+// with one JSON file per day of hourly buckets, per month of daily buckets
+// and per year of monthly buckets.
+package accounting
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sathiraumesh/wireguard_exporter/internal/atomicfile"
+)
+
+// flushInterval is how often recorded traffic is written to disk.
+const flushInterval = time.Minute
+
+// fileVersion is the version of the bucket file format.
+const fileVersion = 1
+
+// Granularity is the length of a bucket.
+type Granularity string
+
+const (
+	Hourly  Granularity = "hourly"
+	Daily   Granularity = "daily"
+	Monthly Granularity = "monthly"
+)
+
+var granularities = []Granularity{Hourly, Daily, Monthly}
+
+// BucketStart returns the start of the bucket containing t.
+func (g Granularity) BucketStart(t time.Time) time.Time {
+	t = t.UTC()
+	switch g {
+	case Hourly:
+		return t.Truncate(time.Hour)
+	case Daily:
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	default:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	}
+}
+
+// fileStart returns the start of the file containing the bucket of t, and
+// the start of the next file.
+func (g Granularity) fileStart(t time.Time) (start, next time.Time) {
+	t = t.UTC()
+	switch g {
+	case Hourly:
+		start = Daily.BucketStart(t)
+		return start, start.AddDate(0, 0, 1)
+	case Daily:
+		start = Monthly.BucketStart(t)
+		return start, start.AddDate(0, 1, 0)
+	default:
+		start = time.Date(t.Year(), 1, 1, 0, 0, 0, 0, time.UTC)
+		return start, start.AddDate(1, 0, 0)
+	}
+}
+
+func (g Granularity) fileName(t time.Time) string {
+	start, _ := g.fileStart(t)
+	switch g {
+	case Hourly:
+		return start.Format("2006-01-02") + ".json"
+	case Daily:
+		return start.Format("2006-01") + ".json"
+	default:
+		return start.Format("2006") + ".json"
+	}
+}
+
+// Peer identifies a peer on an interface. Namespace is empty for the
+// exporter's own network namespace.
+type Peer struct {
+	Namespace string `json:"namespace,omitempty"`
+	Interface string `json:"interface"`
+	PublicKey string `json:"public_key,omitempty"`
+}
+
+func (p Peer) compare(o Peer) int {
+	return cmp.Or(
+		strings.Compare(p.Namespace, o.Namespace),
+		strings.Compare(p.Interface, o.Interface),
+		strings.Compare(p.PublicKey, o.PublicKey),
+	)
+}
+
+// Usage is an amount of traffic in bytes.
+type Usage struct {
+	Transmit int64 `json:"transmit_bytes"`
+	Receive  int64 `json:"receive_bytes"`
+}
+
+// Total returns the transmitted and received bytes together.
+func (u Usage) Total() int64 {
+	return u.Transmit + u.Receive
+}
+
+func (u *Usage) add(o Usage) {
+	u.Transmit += o.Transmit
+	u.Receive += o.Receive
+}
+
+// Record is the traffic of one peer in a bucket.
+type Record struct {
+	Peer
+	Usage
+}
+
+// Bucket is the traffic of every peer between Start and the start of the
+// next bucket.
+type Bucket struct {
+	Start   time.Time `json:"start"`
+	Records []Record  `json:"records"`
+}
+
+type bucketFile struct {
+	Version int      `json:"version"`
+	Buckets []Bucket `json:"buckets"`
+}
+
+// buckets maps bucket starts to the traffic of each peer.
+type buckets map[time.Time]map[Peer]Usage
+
+func (b buckets) add(start time.Time, peer Peer, u Usage) {
+	m, ok := b[start]
+	if !ok {
+		m = make(map[Peer]Usage)
+		b[start] = m
+	}
+	sum := m[peer]
+	sum.add(u)
+	m[peer] = sum
+}
+
+// list returns the buckets sorted by start and peer.
+func (b buckets) list() []Bucket {
+	out := make([]Bucket, 0, len(b))
+	for start, peers := range b {
+		bucket := Bucket{Start: start, Records: make([]Record, 0, len(peers))}
+		for peer, u := range peers {
+			bucket.Records = append(bucket.Records, Record{Peer: peer, Usage: u})
+		}
+		slices.SortFunc(bucket.Records, func(a, b Record) int { return a.Peer.compare(b.Peer) })
+		out = append(out, bucket)
+	}
+	slices.SortFunc(out, func(a, b Bucket) int { return a.Start.Compare(b.Start) })
+	return out
+}
+
+// Store records traffic into the bucket files below a directory. Recorded
+// traffic is kept in memory and written at most every minute, and on
+// Flush or Close.
+type Store struct {
+	dir string
+
+	mu      sync.Mutex
+	pending map[Granularity]buckets
+	flushed time.Time
+	// current holds, per granularity, the bucket last read by Usage,
+	// kept up to date by Record.
+	current  map[Granularity]*currentBucket
+	onFlush  func() error
+	readOnly bool
+}
+
+// currentBucket is the traffic of each peer in the bucket starting at
+// start.
+type currentBucket struct {
+	start time.Time
+	usage map[Peer]Usage
+}
+
+// Open returns the store in dir, creating the directory if needed.
+func Open(dir string) (*Store, error) {
+	for _, g := range granularities {
+		if err := os.MkdirAll(filepath.Join(dir, string(g)), 0o755); err != nil {
+			return nil, err
+		}
+	}
+	return newStore(dir), nil
+}
+
+// OpenReadOnly returns the store in dir for reading. Unlike Open it fails
+// if dir does not exist, so that a mistyped directory is reported rather
+// than read as a store without traffic, and the store refuses to record.
+func OpenReadOnly(dir string) (*Store, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", dir)
+	}
+	s := newStore(dir)
+	s.readOnly = true
+	return s, nil
+}
+
+func newStore(dir string) *Store {
+	return &Store{
+		dir:     dir,
+		pending: make(map[Granularity]buckets),
+		flushed: time.Now(),
+		current: make(map[Granularity]*currentBucket),
+	}
+}
+
+// Record adds traffic seen at the given time to the buckets containing it,
+// writing the buckets to disk once a minute has passed since the last
+// write.
+func (s *Store) Record(at time.Time, usage map[Peer]Usage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.readOnly {
+		return fmt.Errorf("accounting store %s is read-only", s.dir)
+	}
+
+	for _, g := range granularities {
+		start := g.BucketStart(at)
+		for peer, u := range usage {
+			s.pendingFor(g).add(start, peer, u)
+		}
+		if cur := s.current[g]; cur != nil && cur.start.Equal(start) {
+			for peer, u := range usage {
+				sum := cur.usage[peer]
+				sum.add(u)
+				cur.usage[peer] = sum
+			}
+		}
+	}
+	if time.Since(s.flushed) < flushInterval {
+		return nil
+	}
+	return s.flush()
+}
+
+func (s *Store) pendingFor(g Granularity) buckets {
+	b, ok := s.pending[g]
+	if !ok {
+		b = make(buckets)
+		s.pending[g] = b
+	}
+	return b
+}
+
+// OnFlush sets fn to run after every flush that wrote all pending
+// traffic, before the store lock is released. It lets state that must
+// match the buckets on disk, such as the counter values the traffic was
+// computed from, be written in the same step.
+func (s *Store) OnFlush(fn func() error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onFlush = fn
+}
+
+// Flush writes the recorded traffic to disk.
+func (s *Store) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.flush()
+}
+
+// Close flushes the store.
+func (s *Store) Close() error {
+	return s.Flush()
+}
+
+// flush merges the pending buckets into their files. Buckets whose file
+// cannot be written stay pending for the next flush.
+func (s *Store) flush() error {
+	s.flushed = time.Now()
+
+	var errs []error
+	for g, pending := range s.pending {
+		byFile := make(map[string][]time.Time)
+		for start := range pending {
+			name := g.fileName(start)
+			byFile[name] = append(byFile[name], start)
+		}
+		for name, starts := range byFile {
+			path := filepath.Join(s.dir, string(g), name)
+			stored, err := readBuckets(path)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			for _, start := range starts {
+				for peer, u := range pending[start] {
+					stored.add(start, peer, u)
+				}
+			}
+			if err := writeBuckets(path, stored); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			for _, start := range starts {
+				delete(pending, start)
+			}
+		}
+	}
+	if len(errs) == 0 && s.onFlush != nil {
+		errs = append(errs, s.onFlush())
+	}
+	return errors.Join(errs...)
+}
+
+// Buckets returns the buckets of granularity g that start in [from, to),
+// including traffic not yet written to disk.
+func (s *Store) Buckets(g Granularity, from, to time.Time) ([]Bucket, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.readRange(g, from, to)
+}
+
+func (s *Store) readRange(g Granularity, from, to time.Time) ([]Bucket, error) {
+	inRange := make(buckets)
+	addInRange := func(b buckets) {
+		for start, peers := range b {
+			if start.Before(from) || !start.Before(to) {
+				continue
+			}
+			for peer, u := range peers {
+				inRange.add(start, peer, u)
+			}
+		}
+	}
+	for start, _ := g.fileStart(from); start.Before(to); _, start = g.fileStart(start) {
+		stored, err := readBuckets(filepath.Join(s.dir, string(g), g.fileName(start)))
+		if err != nil {
+			return nil, err
+		}
+		addInRange(stored)
+	}
+	addInRange(s.pending[g])
+	return inRange.list(), nil
+}
+
+// Usage returns the traffic of each peer in the bucket of granularity g
+// containing t. The bucket is read from disk once and then kept in memory
+// until Usage asks for another bucket of g, so asking for the current
+// bucket on every scrape is cheap.
+func (s *Store) Usage(g Granularity, t time.Time) (map[Peer]Usage, error) {
+	start := g.BucketStart(t)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cur := s.current[g]
+	if cur == nil || !cur.start.Equal(start) {
+		list, err := s.readRange(g, start, start.Add(time.Nanosecond))
+		if err != nil {
+			return nil, err
+		}
+		cur = &currentBucket{start: start, usage: make(map[Peer]Usage)}
+		for _, b := range list {
+			for _, r := range b.Records {
+				cur.usage[r.Peer] = r.Usage
+			}
+		}
+		s.current[g] = cur
+	}
+	return maps.Clone(cur.usage), nil
+}
+
+func readBuckets(path string) (buckets, error) {
+	b := make(buckets)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return b, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var f bucketFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("decoding %s: %w", path, err)
+	}
+	if f.Version != fileVersion {
+		return nil, fmt.Errorf("%s: unsupported version %d", path, f.Version)
+	}
+	for _, bucket := range f.Buckets {
+		for _, r := range bucket.Records {
+			b.add(bucket.Start.UTC(), r.Peer, r.Usage)
+		}
+	}
+	return b, nil
+}
+
+func writeBuckets(path string, b buckets) error {
+	f := bucketFile{Version: fileVersion, Buckets: b.list()}
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	return atomicfile.Write(path, data)
+}
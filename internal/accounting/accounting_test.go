@@ -0,0 +1,194 @@
+package accounting
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	alice = Peer{Interface: "wg0", PublicKey: "alice"}
+	bob   = Peer{Interface: "wg0", PublicKey: "bob"}
+	carol = Peer{Namespace: "vpn", Interface: "wg0", PublicKey: "carol"}
+)
+
+func TestBucketStart(t *testing.T) {
+	at := time.Date(2026, 10, 17, 13, 45, 10, 0, time.FixedZone("CEST", 2*60*60))
+
+	assert.Equal(t, time.Date(2026, 10, 17, 11, 0, 0, 0, time.UTC), Hourly.BucketStart(at))
+	assert.Equal(t, time.Date(2026, 10, 17, 0, 0, 0, 0, time.UTC), Daily.BucketStart(at))
+	assert.Equal(t, time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC), Monthly.BucketStart(at))
+}
+
+func TestStoreRecordAndFlush(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir)
+	require.NoError(t, err)
+
+	t1 := time.Date(2026, 10, 17, 13, 5, 0, 0, time.UTC)
+	t2 := time.Date(2026, 10, 17, 13, 55, 0, 0, time.UTC)
+	t3 := time.Date(2026, 10, 18, 0, 5, 0, 0, time.UTC)
+	require.NoError(t, s.Record(t1, map[Peer]Usage{alice: {Transmit: 100, Receive: 10}, carol: {Transmit: 1}}))
+	require.NoError(t, s.Record(t2, map[Peer]Usage{alice: {Transmit: 50, Receive: 5}}))
+	require.NoError(t, s.Record(t3, map[Peer]Usage{bob: {Receive: 7}}))
+
+	// Traffic is visible before it is written.
+	usage, err := s.Usage(Monthly, t1)
+	require.NoError(t, err)
+	assert.Equal(t, map[Peer]Usage{
+		alice: {Transmit: 150, Receive: 15},
+		bob:   {Receive: 7},
+		carol: {Transmit: 1},
+	}, usage)
+
+	require.NoError(t, s.Close())
+	for _, name := range []string{"hourly/2026-10-17.json", "hourly/2026-10-18.json", "daily/2026-10.json", "monthly/2026.json"} {
+		assert.FileExists(t, filepath.Join(dir, name))
+	}
+
+	// A reopened store reads the written buckets and adds to them.
+	s, err = Open(dir)
+	require.NoError(t, err)
+	require.NoError(t, s.Record(t3, map[Peer]Usage{alice: {Transmit: 1}}))
+	require.NoError(t, s.Flush())
+
+	hourly, err := s.Buckets(Hourly, Hourly.BucketStart(t1), t3.Add(time.Hour))
+	require.NoError(t, err)
+	require.Len(t, hourly, 2)
+	assert.Equal(t, Bucket{
+		Start: time.Date(2026, 10, 17, 13, 0, 0, 0, time.UTC),
+		Records: []Record{
+			{Peer: alice, Usage: Usage{Transmit: 150, Receive: 15}},
+			{Peer: carol, Usage: Usage{Transmit: 1}},
+		},
+	}, hourly[0])
+	assert.Equal(t, Bucket{
+		Start: time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC),
+		Records: []Record{
+			{Peer: alice, Usage: Usage{Transmit: 1}},
+			{Peer: bob, Usage: Usage{Receive: 7}},
+		},
+	}, hourly[1])
+
+	daily, err := s.Buckets(Daily, time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC), time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+	require.Len(t, daily, 1)
+	assert.Len(t, daily[0].Records, 2)
+}
+
+func TestStoreBucketsAcrossFiles(t *testing.T) {
+	s, err := Open(t.TempDir())
+	require.NoError(t, err)
+
+	for _, at := range []time.Time{
+		time.Date(2025, 12, 31, 12, 0, 0, 0, time.UTC),
+		time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC),
+		time.Date(2026, 2, 1, 12, 0, 0, 0, time.UTC),
+	} {
+		require.NoError(t, s.Record(at, map[Peer]Usage{alice: {Transmit: 1}}))
+	}
+	require.NoError(t, s.Flush())
+
+	daily, err := s.Buckets(Daily, time.Date(2025, 12, 31, 0, 0, 0, 0, time.UTC), time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+	require.Len(t, daily, 2)
+
+	monthly, err := s.Buckets(Monthly, time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+	assert.Len(t, monthly, 3)
+}
+
+func TestStoreUsageKeptInMemory(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir)
+	require.NoError(t, err)
+
+	at := time.Date(2026, 10, 17, 13, 0, 0, 0, time.UTC)
+	require.NoError(t, s.Record(at, map[Peer]Usage{alice: {Transmit: 10}}))
+	require.NoError(t, s.Flush())
+	usage, err := s.Usage(Monthly, at)
+	require.NoError(t, err)
+	assert.Equal(t, map[Peer]Usage{alice: {Transmit: 10}}, usage)
+
+	// Once loaded, the bucket is served from memory and kept up to date.
+	path := filepath.Join(dir, "monthly", "2026.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"version": 9}`), 0o600))
+	require.NoError(t, s.Record(at.Add(time.Hour), map[Peer]Usage{alice: {Receive: 5}}))
+	usage, err = s.Usage(Monthly, at)
+	require.NoError(t, err)
+	assert.Equal(t, map[Peer]Usage{alice: {Transmit: 10, Receive: 5}}, usage)
+
+	// Another bucket is read from disk.
+	_, err = s.Usage(Monthly, at.AddDate(0, 1, 0))
+	assert.ErrorContains(t, err, "unsupported version 9")
+}
+
+func TestStoreOnFlush(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir)
+	require.NoError(t, err)
+	flushes := 0
+	s.OnFlush(func() error {
+		flushes++
+		return nil
+	})
+
+	at := time.Date(2026, 10, 17, 13, 0, 0, 0, time.UTC)
+	require.NoError(t, s.Record(at, map[Peer]Usage{alice: {Transmit: 1}}))
+	require.NoError(t, s.Flush())
+	assert.Equal(t, 1, flushes)
+
+	// A flush that leaves traffic pending does not run the hook.
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "daily", "2026-10.json"), []byte(`{"version": 9}`), 0o600))
+	require.NoError(t, s.Record(at, map[Peer]Usage{alice: {Transmit: 1}}))
+	assert.Error(t, s.Flush())
+	assert.Equal(t, 1, flushes)
+}
+
+func TestOpenReadOnly(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir)
+	require.NoError(t, err)
+	at := time.Date(2026, 10, 17, 13, 0, 0, 0, time.UTC)
+	require.NoError(t, s.Record(at, map[Peer]Usage{alice: {Transmit: 1}}))
+	require.NoError(t, s.Close())
+
+	r, err := OpenReadOnly(dir)
+	require.NoError(t, err)
+	u, err := r.Usage(Daily, at)
+	require.NoError(t, err)
+	assert.Equal(t, Usage{Transmit: 1}, u[alice])
+	assert.ErrorContains(t, r.Record(at, map[Peer]Usage{alice: {Transmit: 1}}), "read-only")
+
+	// A missing directory is an error and is not created.
+	missing := filepath.Join(dir, "missing")
+	_, err = OpenReadOnly(missing)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	assert.NoDirExists(t, missing)
+
+	_, err = OpenReadOnly(filepath.Join(dir, "daily", "2026-10.json"))
+	assert.ErrorContains(t, err, "not a directory")
+}
+
+func TestStoreInvalidFile(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir)
+	require.NoError(t, err)
+
+	at := time.Date(2026, 10, 17, 13, 0, 0, 0, time.UTC)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "daily", "2026-10.json"), []byte(`{"version": 9}`), 0o600))
+
+	_, err = s.Usage(Daily, at)
+	assert.ErrorContains(t, err, "unsupported version 9")
+
+	// The unwritable bucket stays pending; the others are written.
+	require.NoError(t, s.Record(at, map[Peer]Usage{alice: {Transmit: 1}}))
+	assert.Error(t, s.Flush())
+	assert.FileExists(t, filepath.Join(dir, "hourly", "2026-10-17.json"))
+	assert.Len(t, s.pending[Daily], 1)
+	assert.Empty(t, s.pending[Hourly])
+}
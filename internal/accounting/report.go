@@ -0,0 +1,88 @@
+package accounting
+
+import (
+	"fmt"
+	"slices"
+	"time"
+)
+
+// Period selects how a report breaks down its range.
+type Period string
+
+const (
+	PeriodTotal Period = "total"
+	PeriodHour  Period = "hour"
+	PeriodDay   Period = "day"
+	PeriodMonth Period = "month"
+)
+
+// ParsePeriod validates a report period name.
+func ParsePeriod(s string) (Period, error) {
+	switch p := Period(s); p {
+	case PeriodTotal, PeriodHour, PeriodDay, PeriodMonth:
+		return p, nil
+	default:
+		return "", fmt.Errorf("period must be %q, %q, %q or %q, got %q", PeriodTotal, PeriodHour, PeriodDay, PeriodMonth, s)
+	}
+}
+
+// Row is the traffic of a peer, or of a whole interface when PublicKey is
+// empty, in one period of a report. Start is the start of the period, or
+// of the report range for PeriodTotal.
+type Row struct {
+	Start time.Time
+	Peer
+	Usage
+}
+
+// Report sums the traffic in [from, to) per period and peer, or per
+// interface when byInterface is set. Hour periods are read from the hourly
+// buckets and the others from the daily buckets, so from and to should be
+// aligned to hours or days respectively.
+func (s *Store) Report(from, to time.Time, period Period, byInterface bool) ([]Row, error) {
+	g := Daily
+	if period == PeriodHour {
+		g = Hourly
+	}
+	list, err := s.Buckets(g, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	type rowKey struct {
+		start time.Time
+		peer  Peer
+	}
+	sums := make(map[rowKey]Usage)
+	for _, b := range list {
+		start := b.Start
+		switch period {
+		case PeriodTotal:
+			start = from.UTC()
+		case PeriodMonth:
+			start = Monthly.BucketStart(start)
+		}
+		for _, r := range b.Records {
+			peer := r.Peer
+			if byInterface {
+				peer.PublicKey = ""
+			}
+			key := rowKey{start: start, peer: peer}
+			sum := sums[key]
+			sum.add(r.Usage)
+			sums[key] = sum
+		}
+	}
+
+	rows := make([]Row, 0, len(sums))
+	for key, u := range sums {
+		rows = append(rows, Row{Start: key.start, Peer: key.peer, Usage: u})
+	}
+	slices.SortFunc(rows, func(a, b Row) int {
+		if c := a.Start.Compare(b.Start); c != 0 {
+			return c
+		}
+		return a.Peer.compare(b.Peer)
+	})
+	return rows, nil
+}
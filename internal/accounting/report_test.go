@@ -0,0 +1,95 @@
+package accounting
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReport(t *testing.T) {
+	s, err := Open(t.TempDir())
+	require.NoError(t, err)
+
+	day := func(d, h int) time.Time { return time.Date(2026, 10, d, h, 0, 0, 0, time.UTC) }
+	require.NoError(t, s.Record(day(1, 1), map[Peer]Usage{alice: {Transmit: 10}, bob: {Receive: 1}}))
+	require.NoError(t, s.Record(day(1, 2), map[Peer]Usage{alice: {Transmit: 20}}))
+	require.NoError(t, s.Record(day(2, 1), map[Peer]Usage{alice: {Receive: 5}, carol: {Transmit: 3}}))
+	require.NoError(t, s.Record(day(3, 1), map[Peer]Usage{alice: {Transmit: 1000}}))
+	require.NoError(t, s.Flush())
+
+	from, to := day(1, 0), day(3, 0)
+	tests := []struct {
+		name        string
+		period      Period
+		byInterface bool
+		want        []Row
+	}{
+		{
+			name:   "total per peer",
+			period: PeriodTotal,
+			want: []Row{
+				{Start: from, Peer: alice, Usage: Usage{Transmit: 30, Receive: 5}},
+				{Start: from, Peer: bob, Usage: Usage{Receive: 1}},
+				{Start: from, Peer: carol, Usage: Usage{Transmit: 3}},
+			},
+		},
+		{
+			name:        "total per interface",
+			period:      PeriodTotal,
+			byInterface: true,
+			want: []Row{
+				{Start: from, Peer: Peer{Interface: "wg0"}, Usage: Usage{Transmit: 30, Receive: 6}},
+				{Start: from, Peer: Peer{Namespace: "vpn", Interface: "wg0"}, Usage: Usage{Transmit: 3}},
+			},
+		},
+		{
+			name:        "per day",
+			period:      PeriodDay,
+			byInterface: true,
+			want: []Row{
+				{Start: day(1, 0), Peer: Peer{Interface: "wg0"}, Usage: Usage{Transmit: 30, Receive: 1}},
+				{Start: day(2, 0), Peer: Peer{Interface: "wg0"}, Usage: Usage{Receive: 5}},
+				{Start: day(2, 0), Peer: Peer{Namespace: "vpn", Interface: "wg0"}, Usage: Usage{Transmit: 3}},
+			},
+		},
+		{
+			name:   "per hour",
+			period: PeriodHour,
+			want: []Row{
+				{Start: day(1, 1), Peer: alice, Usage: Usage{Transmit: 10}},
+				{Start: day(1, 1), Peer: bob, Usage: Usage{Receive: 1}},
+				{Start: day(1, 2), Peer: alice, Usage: Usage{Transmit: 20}},
+				{Start: day(2, 1), Peer: alice, Usage: Usage{Receive: 5}},
+				{Start: day(2, 1), Peer: carol, Usage: Usage{Transmit: 3}},
+			},
+		},
+		{
+			name:        "per month",
+			period:      PeriodMonth,
+			byInterface: true,
+			want: []Row{
+				{Start: day(1, 0), Peer: Peer{Interface: "wg0"}, Usage: Usage{Transmit: 30, Receive: 6}},
+				{Start: day(1, 0), Peer: Peer{Namespace: "vpn", Interface: "wg0"}, Usage: Usage{Transmit: 3}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rows, err := s.Report(from, to, tt.period, tt.byInterface)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, rows)
+		})
+	}
+}
+
+func TestParsePeriod(t *testing.T) {
+	p, err := ParsePeriod("day")
+	assert.NoError(t, err)
+	assert.Equal(t, PeriodDay, p)
+
+	_, err = ParsePeriod("week")
+	assert.EqualError(t, err, `period must be "total", "hour", "day" or "month", got "week"`)
+}
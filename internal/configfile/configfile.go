@@ -0,0 +1,110 @@
+// Package configfile decodes YAML or JSON configuration files and reloads
+// them when they change on disk.
+package configfile
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// IsJSON reports whether the file at path is JSON, judging by its
+// extension. Any other file is YAML.
+func IsJSON(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), ".json")
+}
+
+// Decode decodes data into v, as JSON when isJSON is set and YAML
+// otherwise. Unknown fields are rejected; an empty YAML document leaves v
+// unchanged.
+func Decode(data []byte, isJSON bool, v any) error {
+	if isJSON {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(v); err != nil {
+			return fmt.Errorf("decoding JSON: %w", err)
+		}
+		return nil
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(v); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("decoding YAML: %w", err)
+	}
+	return nil
+}
+
+// Source serves the value parsed from a file and re-parses the file
+// whenever its modification time or size changes.
+type Source[T any] struct {
+	path  string
+	kind  string
+	parse func(data []byte, isJSON bool) (T, error)
+
+	mu      sync.Mutex
+	modTime time.Time
+	size    int64
+	current T
+}
+
+// NewSource loads the file at path with parse. kind names the file in log
+// messages, such as "quota file". The initial load must succeed; later
+// reload failures keep the previously loaded value.
+func NewSource[T any](path, kind string, parse func(data []byte, isJSON bool) (T, error)) (*Source[T], error) {
+	s := &Source[T]{path: path, kind: kind, parse: parse}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.load(info); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Get returns the current value, reloading the file first if it has
+// changed since the last call.
+func (s *Source[T]) Get() T {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	info, err := os.Stat(s.path)
+	if err != nil {
+		slog.Warn("failed to stat "+s.kind+", keeping previous content", "path", s.path, "error", err)
+		return s.current
+	}
+	if info.ModTime().Equal(s.modTime) && info.Size() == s.size {
+		return s.current
+	}
+	if err := s.load(info); err != nil {
+		slog.Warn("failed to reload "+s.kind+", keeping previous content", "path", s.path, "error", err)
+		return s.current
+	}
+	slog.Info("reloaded "+s.kind, "path", s.path)
+	return s.current
+}
+
+func (s *Source[T]) load(info os.FileInfo) error {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+	v, err := s.parse(data, IsJSON(s.path))
+	if err != nil {
+		return fmt.Errorf("%s: %w", s.path, err)
+	}
+	s.current = v
+	s.modTime = info.ModTime()
+	s.size = info.Size()
+	return nil
+}
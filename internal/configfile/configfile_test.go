@@ -0,0 +1,79 @@
+package configfile
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type config struct {
+	Name string `yaml:"name" json:"name"`
+}
+
+func TestDecode(t *testing.T) {
+	var c config
+	require.NoError(t, Decode([]byte("name: a\n"), false, &c))
+	assert.Equal(t, "a", c.Name)
+	require.NoError(t, Decode([]byte(`{"name": "b"}`), true, &c))
+	assert.Equal(t, "b", c.Name)
+
+	// An empty YAML file leaves the value unchanged.
+	require.NoError(t, Decode(nil, false, &c))
+	assert.Equal(t, "b", c.Name)
+
+	assert.ErrorContains(t, Decode([]byte("other: a\n"), false, &c), "decoding YAML")
+	assert.ErrorContains(t, Decode([]byte(`{"other": "a"}`), true, &c), "decoding JSON")
+}
+
+func TestIsJSON(t *testing.T) {
+	assert.True(t, IsJSON("/etc/peers.JSON"))
+	assert.False(t, IsJSON("/etc/peers.yaml"))
+	assert.False(t, IsJSON("/etc/peers"))
+}
+
+func TestSourceReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	mtime := time.Now().Add(-time.Hour)
+	write := func(content string, mtime time.Time) {
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+		require.NoError(t, os.Chtimes(path, mtime, mtime))
+	}
+	parses := 0
+	parse := func(data []byte, isJSON bool) (string, error) {
+		parses++
+		var c config
+		if err := Decode(data, isJSON, &c); err != nil {
+			return "", err
+		}
+		if c.Name == "" {
+			return "", errors.New("name is required")
+		}
+		return c.Name, nil
+	}
+
+	write("name: a\n", mtime)
+	src, err := NewSource(path, "test file", parse)
+	require.NoError(t, err)
+	assert.Equal(t, "a", src.Get())
+	assert.Equal(t, 1, parses)
+
+	// An unchanged file is not parsed again.
+	assert.Equal(t, "a", src.Get())
+	assert.Equal(t, 1, parses)
+
+	write("name: b\n", mtime.Add(time.Minute))
+	assert.Equal(t, "b", src.Get())
+
+	// An invalid file keeps the previous value.
+	write("name: \n", mtime.Add(2*time.Minute))
+	assert.Equal(t, "b", src.Get())
+	assert.Equal(t, 3, parses)
+
+	_, err = NewSource(filepath.Join(t.TempDir(), "missing.yaml"), "test file", parse)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
@@ -0,0 +1,193 @@
+// Package container maps network namespaces to the containers owning them
+// by walking /proc/<pid>/ns/net and the cgroup of each process.
+package container
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultProcRoot and DefaultDockerRoot are the standard locations of
+// procfs and of the Docker container configs used to look up names.
+const (
+	DefaultProcRoot   = "/proc"
+	DefaultDockerRoot = "/var/lib/docker/containers"
+)
+
+var containerIDRE = regexp.MustCompile(`[0-9a-f]{64}`)
+
+// Info describes the container owning a network namespace.
+type Info struct {
+	ID   string
+	Name string
+	PID  int
+}
+
+// Resolver resolves namespaces using the procfs mounted at ProcRoot.
+// Container names are read from Docker configs under DockerRoot; when that
+// is unavailable the short container ID is used as the name.
+//
+// Owners are cached by namespace inode, so procfs is only walked again
+// when a namespace shows up that the previous call did not ask about.
+type Resolver struct {
+	ProcRoot   string
+	DockerRoot string
+
+	// mu guards owners, the owner of every namespace inode asked about by
+	// the previous call. A nil owner records that no container owns it.
+	mu     sync.Mutex
+	owners map[uint64]*Info
+}
+
+// NewResolver returns a Resolver reading procRoot and dockerRoot.
+func NewResolver(procRoot, dockerRoot string) *Resolver {
+	return &Resolver{ProcRoot: procRoot, DockerRoot: dockerRoot}
+}
+
+// Resolve maps each namespace path to the container owning it. The owner
+// is the container of the lowest PID in the namespace. Paths whose
+// namespace contains no containerised process are omitted.
+func (r *Resolver) Resolve(nsPaths []string) map[string]Info {
+	paths := make(map[uint64][]string, len(nsPaths))
+	for _, p := range nsPaths {
+		ino, err := namespaceInode(p)
+		if err != nil {
+			slog.Debug("cannot identify network namespace", "path", p, "error", err)
+			continue
+		}
+		paths[ino] = append(paths[ino], p)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Namespaces that are no longer asked about are dropped from the
+	// cache, so a reused inode is resolved afresh.
+	owners := make(map[uint64]*Info, len(paths))
+	unknown := make(map[uint64]struct{})
+	for ino := range paths {
+		if owner, ok := r.owners[ino]; ok {
+			owners[ino] = owner
+		} else {
+			unknown[ino] = struct{}{}
+		}
+	}
+	if len(unknown) > 0 {
+		for ino, owner := range r.walk(unknown) {
+			owners[ino] = owner
+		}
+	}
+	r.owners = owners
+
+	result := make(map[string]Info)
+	for ino, owner := range owners {
+		if owner == nil {
+			continue
+		}
+		for _, p := range paths[ino] {
+			result[p] = *owner
+		}
+	}
+	return result
+}
+
+// walk looks up the owners of the wanted namespace inodes in procfs. Every
+// wanted inode is in the result, with a nil owner when the lowest PID in
+// the namespace is not containerised or no process uses it. The result is
+// empty when procfs cannot be read.
+func (r *Resolver) walk(wanted map[uint64]struct{}) map[uint64]*Info {
+	pids, err := r.pids()
+	if err != nil {
+		slog.Warn("failed to read procfs", "path", r.ProcRoot, "error", err)
+		return nil
+	}
+
+	owners := make(map[uint64]*Info, len(wanted))
+	for ino := range wanted {
+		owners[ino] = nil
+	}
+	for _, pid := range pids {
+		if len(wanted) == 0 {
+			break
+		}
+		procDir := filepath.Join(r.ProcRoot, strconv.Itoa(pid))
+		ino, err := namespaceInode(filepath.Join(procDir, "ns", "net"))
+		if err != nil {
+			continue
+		}
+		if _, ok := wanted[ino]; !ok {
+			continue
+		}
+		delete(wanted, ino)
+
+		id := r.containerID(procDir)
+		if id == "" {
+			continue
+		}
+		owners[ino] = &Info{ID: id, Name: r.containerName(id), PID: pid}
+	}
+	return owners
+}
+
+// pids returns the numeric entries of ProcRoot in ascending order.
+func (r *Resolver) pids() ([]int, error) {
+	entries, err := os.ReadDir(r.ProcRoot)
+	if err != nil {
+		return nil, err
+	}
+	var pids []int
+	for _, e := range entries {
+		if pid, err := strconv.Atoi(e.Name()); err == nil {
+			pids = append(pids, pid)
+		}
+	}
+	sort.Ints(pids)
+	return pids, nil
+}
+
+func (r *Resolver) containerID(procDir string) string {
+	data, err := os.ReadFile(filepath.Join(procDir, "cgroup"))
+	if err != nil {
+		return ""
+	}
+	return ParseCgroup(string(data))
+}
+
+// ParseCgroup extracts a container ID from the content of a
+// /proc/<pid>/cgroup file. It recognises the 64 hex digit IDs used by
+// Docker, containerd, CRI-O and Podman in both cgroup v1 and v2 layouts.
+func ParseCgroup(content string) string {
+	for _, line := range strings.Split(content, "\n") {
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		if ids := containerIDRE.FindAllString(parts[2], -1); len(ids) > 0 {
+			return ids[len(ids)-1]
+		}
+	}
+	return ""
+}
+
+// containerName returns the Docker name of the container, or its short ID.
+func (r *Resolver) containerName(id string) string {
+	if r.DockerRoot != "" {
+		data, err := os.ReadFile(filepath.Join(r.DockerRoot, id, "config.v2.json"))
+		if err == nil {
+			var cfg struct {
+				Name string `json:"Name"`
+			}
+			if json.Unmarshal(data, &cfg) == nil && cfg.Name != "" {
+				return strings.TrimPrefix(cfg.Name, "/")
+			}
+		}
+	}
+	return id[:12]
+}
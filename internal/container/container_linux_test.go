@@ -0,0 +1,104 @@
+package container
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const otherID = "aaaabbbbccccddddeeeeffff0000111122223333444455556666777788889999"
+
+// fakeProc builds a procfs-like tree where each pid's ns/net links to a
+// file standing in for a namespace.
+func fakeProc(t *testing.T, nsDir string, procs map[int][2]string) string {
+	t.Helper()
+	root := t.TempDir()
+	for pid, p := range procs {
+		ns, cgroup := p[0], p[1]
+		dir := filepath.Join(root, strconv.Itoa(pid))
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, "ns"), 0o755))
+		require.NoError(t, os.Symlink(filepath.Join(nsDir, ns), filepath.Join(dir, "ns", "net")))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "cgroup"), []byte(cgroup), 0o644))
+	}
+	return root
+}
+
+func TestResolve(t *testing.T) {
+	nsDir := t.TempDir()
+	for _, ns := range []string{"host", "tenant", "app"} {
+		require.NoError(t, os.WriteFile(filepath.Join(nsDir, ns), nil, 0o600))
+	}
+
+	procRoot := fakeProc(t, nsDir, map[int][2]string{
+		1:   {"host", "0::/init.scope\n"},
+		200: {"tenant", "0::/user.slice\n"},
+		300: {"app", "0::/system.slice/docker-" + testID + ".scope\n"},
+		301: {"app", "0::/system.slice/docker-" + otherID + ".scope\n"},
+	})
+
+	dockerRoot := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dockerRoot, testID), 0o755))
+	require.NoError(t, os.WriteFile(
+		filepath.Join(dockerRoot, testID, "config.v2.json"),
+		[]byte(`{"ID": "`+testID+`", "Name": "/wireguard_exporter_1"}`), 0o644))
+
+	r := NewResolver(procRoot, dockerRoot)
+	appPath := filepath.Join(nsDir, "app")
+	result := r.Resolve([]string{
+		filepath.Join(nsDir, "host"),
+		filepath.Join(nsDir, "tenant"),
+		appPath,
+		filepath.Join(nsDir, "missing"),
+	})
+
+	assert.Equal(t, map[string]Info{
+		appPath: {ID: testID, Name: "wireguard_exporter_1", PID: 300},
+	}, result)
+}
+
+func TestResolveWithoutDockerConfig(t *testing.T) {
+	nsDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(nsDir, "app"), nil, 0o600))
+	procRoot := fakeProc(t, nsDir, map[int][2]string{
+		42: {"app", "12:pids:/docker/" + testID + "\n"},
+	})
+
+	r := NewResolver(procRoot, filepath.Join(t.TempDir(), "missing"))
+	result := r.Resolve([]string{filepath.Join(nsDir, "app")})
+
+	require.Contains(t, result, filepath.Join(nsDir, "app"))
+	assert.Equal(t, testID[:12], result[filepath.Join(nsDir, "app")].Name)
+}
+
+func TestResolveCachesOwners(t *testing.T) {
+	nsDir := t.TempDir()
+	for _, ns := range []string{"app", "other"} {
+		require.NoError(t, os.WriteFile(filepath.Join(nsDir, ns), nil, 0o600))
+	}
+	procRoot := fakeProc(t, nsDir, map[int][2]string{
+		300: {"app", "0::/system.slice/docker-" + testID + ".scope\n"},
+		400: {"other", "0::/system.slice/docker-" + otherID + ".scope\n"},
+	})
+	appPath := filepath.Join(nsDir, "app")
+	otherPath := filepath.Join(nsDir, "other")
+
+	r := NewResolver(procRoot, "")
+	assert.Equal(t, testID, r.Resolve([]string{appPath})[appPath].ID)
+
+	// Known namespaces are served from the cache without reading procfs.
+	require.NoError(t, os.WriteFile(filepath.Join(procRoot, "300", "cgroup"), []byte("0::/system.slice/docker-"+otherID+".scope\n"), 0o644))
+	assert.Equal(t, testID, r.Resolve([]string{appPath})[appPath].ID)
+
+	// A new namespace walks procfs again.
+	result := r.Resolve([]string{appPath, otherPath})
+	assert.Equal(t, testID, result[appPath].ID)
+	assert.Equal(t, otherID, result[otherPath].ID)
+
+	// Namespaces that are no longer asked about are forgotten.
+	r.Resolve([]string{otherPath})
+	assert.Equal(t, otherID, r.Resolve([]string{appPath})[appPath].ID)
+}
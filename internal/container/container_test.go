@@ -0,0 +1,54 @@
+package container
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testID = "3f4e1c2a9b8d7e6f5a4b3c2d1e0f9a8b7c6d5e4f3a2b1c0d9e8f7a6b5c4d3e2f"
+
+func TestParseCgroup(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		expected string
+	}{
+		{
+			name:     "docker cgroup v1",
+			content:  "12:pids:/docker/" + testID + "\n11:memory:/docker/" + testID + "\n",
+			expected: testID,
+		},
+		{
+			name:     "docker cgroup v2 with systemd driver",
+			content:  "0::/system.slice/docker-" + testID + ".scope\n",
+			expected: testID,
+		},
+		{
+			name:     "containerd in kubernetes",
+			content:  "0::/kubepods.slice/kubepods-burstable.slice/kubepods-burstable-pod1234.slice/cri-containerd-" + testID + ".scope\n",
+			expected: testID,
+		},
+		{
+			name:     "podman",
+			content:  "0::/machine.slice/libpod-" + testID + ".scope/container\n",
+			expected: testID,
+		},
+		{
+			name:     "host process",
+			content:  "0::/init.scope\n",
+			expected: "",
+		},
+		{
+			name:     "empty",
+			content:  "",
+			expected: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, ParseCgroup(tt.content))
+		})
+	}
+}
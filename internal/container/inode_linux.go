@@ -0,0 +1,20 @@
+package container
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// namespaceInode returns the inode identifying the namespace at path.
+func namespaceInode(path string) (uint64, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, fmt.Errorf("%s: unsupported file info", path)
+	}
+	return uint64(st.Ino), nil
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package container
+
+import "errors"
+
+// namespaceInode returns the inode identifying the namespace at path.
+func namespaceInode(path string) (uint64, error) {
+	return 0, errors.New("network namespaces are only supported on Linux")
+}
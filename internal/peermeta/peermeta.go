@@ -0,0 +1,98 @@
+// Package peermeta loads human-readable names and extra labels for
+// WireGuard peers from a YAML or JSON file keyed by public key.
+package peermeta
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/sathiraumesh/wireguard_exporter/internal/configfile"
+)
+
+var labelNameRE = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// Peer holds the metadata configured for a single peer.
+type Peer struct {
+	Name   string            `yaml:"name" json:"name"`
+	Labels map[string]string `yaml:"labels" json:"labels"`
+}
+
+type file struct {
+	Peers map[string]Peer `yaml:"peers" json:"peers"`
+}
+
+// Metadata is the parsed content of a peer metadata file.
+type Metadata struct {
+	peers     map[string]Peer
+	labelKeys []string
+}
+
+// Parse decodes metadata from data. JSON is used when isJSON is set,
+// YAML otherwise.
+func Parse(data []byte, isJSON bool) (*Metadata, error) {
+	var f file
+	if err := configfile.Decode(data, isJSON, &f); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]struct{})
+	peers := make(map[string]Peer, len(f.Peers))
+	for pubKey, peer := range f.Peers {
+		for name := range peer.Labels {
+			if !labelNameRE.MatchString(name) || strings.HasPrefix(name, "__") {
+				return nil, fmt.Errorf("peer %s: invalid label name %q", pubKey, name)
+			}
+			keys[name] = struct{}{}
+		}
+		peers[strings.TrimSpace(pubKey)] = peer
+	}
+
+	labelKeys := make([]string, 0, len(keys))
+	for k := range keys {
+		labelKeys = append(labelKeys, k)
+	}
+	sort.Strings(labelKeys)
+
+	return &Metadata{peers: peers, labelKeys: labelKeys}, nil
+}
+
+// LabelKeys returns the sorted union of extra label names used by any peer.
+func (m *Metadata) LabelKeys() []string {
+	if m == nil {
+		return nil
+	}
+	return m.labelKeys
+}
+
+// Lookup returns the metadata for the peer with the given base64 public key.
+func (m *Metadata) Lookup(publicKey string) (Peer, bool) {
+	if m == nil {
+		return Peer{}, false
+	}
+	p, ok := m.peers[publicKey]
+	return p, ok
+}
+
+// Source serves metadata from a file and re-reads it whenever the file's
+// modification time or size changes.
+type Source struct {
+	file *configfile.Source[*Metadata]
+}
+
+// NewSource loads the peer metadata file at path. The initial load must succeed;
+// later reload failures keep the previously loaded metadata.
+func NewSource(path string) (*Source, error) {
+	file, err := configfile.NewSource(path, "peer metadata file", Parse)
+	if err != nil {
+		return nil, err
+	}
+	return &Source{file: file}, nil
+}
+
+// Metadata returns the current metadata, reloading the file first if it
+// has changed since the last call.
+func (s *Source) Metadata() *Metadata {
+	return s.file.Get()
+}
@@ -0,0 +1,120 @@
+package peermeta
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const yamlMetadata = `
+peers:
+  "AQAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=":
+    name: alice-laptop
+    labels:
+      team: infra
+  "AgAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=":
+    name: ams-site
+    labels:
+      site: ams
+`
+
+func writeFile(t *testing.T, path, content string, mtime time.Time) {
+	t.Helper()
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	require.NoError(t, os.Chtimes(path, mtime, mtime))
+}
+
+func TestParseYAML(t *testing.T) {
+	md, err := Parse([]byte(yamlMetadata), false)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"site", "team"}, md.LabelKeys())
+
+	peer, ok := md.Lookup("AQAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=")
+	require.True(t, ok)
+	assert.Equal(t, "alice-laptop", peer.Name)
+	assert.Equal(t, "infra", peer.Labels["team"])
+
+	_, ok = md.Lookup("unknown")
+	assert.False(t, ok)
+}
+
+func TestParseJSON(t *testing.T) {
+	data := `{"peers": {"AQAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=": {"name": "alice-laptop", "labels": {"team": "infra"}}}}`
+	md, err := Parse([]byte(data), true)
+	require.NoError(t, err)
+
+	peer, ok := md.Lookup("AQAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=")
+	require.True(t, ok)
+	assert.Equal(t, "alice-laptop", peer.Name)
+	assert.Equal(t, []string{"team"}, md.LabelKeys())
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   string
+		isJSON bool
+	}{
+		{name: "invalid label name", data: "peers:\n  k:\n    labels:\n      bad-label: x\n"},
+		{name: "reserved label prefix", data: "peers:\n  k:\n    labels:\n      __meta: x\n"},
+		{name: "unknown field", data: "peers:\n  k:\n    nickname: x\n"},
+		{name: "malformed JSON", data: `{"peers": `, isJSON: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse([]byte(tt.data), tt.isJSON)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestParseEmpty(t *testing.T) {
+	md, err := Parse(nil, false)
+	require.NoError(t, err)
+	assert.Empty(t, md.LabelKeys())
+}
+
+func TestSourceReloadsOnChange(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "peers.yml")
+	mtime := time.Now().Add(-time.Hour)
+	writeFile(t, path, yamlMetadata, mtime)
+
+	src, err := NewSource(path)
+	require.NoError(t, err)
+
+	peer, _ := src.Metadata().Lookup("AQAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=")
+	assert.Equal(t, "alice-laptop", peer.Name)
+
+	updated := "peers:\n  \"AQAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=\":\n    name: alice-desktop\n"
+	writeFile(t, path, updated, mtime.Add(time.Minute))
+
+	peer, _ = src.Metadata().Lookup("AQAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=")
+	assert.Equal(t, "alice-desktop", peer.Name)
+	assert.Empty(t, src.Metadata().LabelKeys())
+}
+
+func TestSourceKeepsPreviousOnInvalidReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "peers.yml")
+	mtime := time.Now().Add(-time.Hour)
+	writeFile(t, path, yamlMetadata, mtime)
+
+	src, err := NewSource(path)
+	require.NoError(t, err)
+
+	writeFile(t, path, "peers: [", mtime.Add(time.Minute))
+
+	peer, ok := src.Metadata().Lookup("AQAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=")
+	require.True(t, ok)
+	assert.Equal(t, "alice-laptop", peer.Name)
+}
+
+func TestNewSourceMissingFile(t *testing.T) {
+	_, err := NewSource(filepath.Join(t.TempDir(), "missing.yml"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,188 @@
+// Package quota loads per-peer traffic quotas from a YAML or JSON file.
+// Quotas are declared for a public key or for a group of peers that share
+// peer metadata labels.
+package quota
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/sathiraumesh/wireguard_exporter/internal/configfile"
+	"gopkg.in/yaml.v3"
+)
+
+// Window is the billing window a quota applies to.
+type Window string
+
+const (
+	Daily   Window = "daily"
+	Monthly Window = "monthly"
+)
+
+// Size is a number of bytes. In files it is written as a plain number or
+// with a unit, such as "500MB" or "10GiB".
+type Size int64
+
+var sizeRE = regexp.MustCompile(`^(\d+(?:\.\d+)?)\s*([kmgtp]i?b|b)?$`)
+
+var sizeUnits = map[string]float64{
+	"": 1, "b": 1,
+	"kb": 1e3, "mb": 1e6, "gb": 1e9, "tb": 1e12, "pb": 1e15,
+	"kib": 1 << 10, "mib": 1 << 20, "gib": 1 << 30, "tib": 1 << 40, "pib": 1 << 50,
+}
+
+// ParseSize parses a size such as "1048576", "500MB" or "10GiB".
+func ParseSize(s string) (Size, error) {
+	m := sizeRE.FindStringSubmatch(strings.ToLower(strings.TrimSpace(s)))
+	if m == nil {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	n, err := strconv.ParseFloat(m[1], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	// float64(math.MaxInt64) rounds up to 2^63, which does not fit.
+	v := n * sizeUnits[m[2]]
+	if math.IsNaN(v) || v < 0 || v >= math.MaxInt64 {
+		return 0, fmt.Errorf("size %q is out of range", s)
+	}
+	return Size(v), nil
+}
+
+func (s *Size) UnmarshalYAML(node *yaml.Node) error {
+	v, err := ParseSize(node.Value)
+	if err != nil {
+		return err
+	}
+	*s = v
+	return nil
+}
+
+func (s *Size) UnmarshalJSON(data []byte) error {
+	var str string
+	if err := json.Unmarshal(data, &str); err != nil {
+		str = string(data)
+	}
+	v, err := ParseSize(str)
+	if err != nil {
+		return err
+	}
+	*s = v
+	return nil
+}
+
+// Rule declares a quota for the peer with PublicKey, or for every peer
+// whose metadata has all of Labels.
+type Rule struct {
+	PublicKey string            `yaml:"public_key" json:"public_key"`
+	Labels    map[string]string `yaml:"labels" json:"labels"`
+	Bytes     Size              `yaml:"bytes" json:"bytes"`
+}
+
+type file struct {
+	Window Window `yaml:"window" json:"window"`
+	Quotas []Rule `yaml:"quotas" json:"quotas"`
+}
+
+// Config is the parsed content of a quota file.
+type Config struct {
+	window Window
+	byKey  map[string]Size
+	groups []Rule
+}
+
+// Parse decodes quotas from data. JSON is used when isJSON is set, YAML
+// otherwise. The window defaults to Monthly.
+func Parse(data []byte, isJSON bool) (*Config, error) {
+	var f file
+	if err := configfile.Decode(data, isJSON, &f); err != nil {
+		return nil, err
+	}
+
+	c := &Config{window: f.Window, byKey: make(map[string]Size)}
+	switch c.window {
+	case "":
+		c.window = Monthly
+	case Daily, Monthly:
+	default:
+		return nil, fmt.Errorf("window must be %q or %q, got %q", Daily, Monthly, f.Window)
+	}
+	for i, r := range f.Quotas {
+		r.PublicKey = strings.TrimSpace(r.PublicKey)
+		switch {
+		case r.Bytes <= 0:
+			return nil, fmt.Errorf("quota %d: bytes must be positive", i+1)
+		case (r.PublicKey == "") == (len(r.Labels) == 0):
+			return nil, fmt.Errorf("quota %d: exactly one of public_key and labels must be set", i+1)
+		case r.PublicKey != "":
+			if _, ok := c.byKey[r.PublicKey]; ok {
+				return nil, fmt.Errorf("quota %d: duplicate quota for peer %s", i+1, r.PublicKey)
+			}
+			c.byKey[r.PublicKey] = r.Bytes
+		default:
+			c.groups = append(c.groups, r)
+		}
+	}
+	return c, nil
+}
+
+// HasGroups reports whether any quota applies to a group of peers by
+// their metadata labels.
+func (c *Config) HasGroups() bool {
+	return len(c.groups) > 0
+}
+
+// Window returns the billing window of the quotas.
+func (c *Config) Window() Window {
+	return c.window
+}
+
+// Lookup returns the quota of the peer with the given public key and
+// metadata labels. A quota for the public key wins over group quotas;
+// among group quotas the first whose labels all match applies.
+func (c *Config) Lookup(publicKey string, labels map[string]string) (Size, bool) {
+	if size, ok := c.byKey[publicKey]; ok {
+		return size, true
+	}
+	for _, r := range c.groups {
+		if matchLabels(r.Labels, labels) {
+			return r.Bytes, true
+		}
+	}
+	return 0, false
+}
+
+func matchLabels(want, have map[string]string) bool {
+	for k, v := range want {
+		if got, ok := have[k]; !ok || got != v {
+			return false
+		}
+	}
+	return true
+}
+
+// Source serves quotas from a file and re-reads it whenever the file's
+// modification time or size changes.
+type Source struct {
+	file *configfile.Source[*Config]
+}
+
+// NewSource loads the quota file at path. The initial load must succeed;
+// later reload failures keep the previously loaded quotas.
+func NewSource(path string) (*Source, error) {
+	file, err := configfile.NewSource(path, "quota file", Parse)
+	if err != nil {
+		return nil, err
+	}
+	return &Source{file: file}, nil
+}
+
+// Config returns the current quotas, reloading the file first if it has
+// changed since the last call.
+func (s *Source) Config() *Config {
+	return s.file.Get()
+}
@@ -0,0 +1,140 @@
+package quota
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Size
+	}{
+		{"1048576", 1048576},
+		{"500MB", 500_000_000},
+		{"10GiB", 10 << 30},
+		{"1.5 TB", 1_500_000_000_000},
+		{"2kib", 2048},
+		{"7 B", 7},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseSize(tt.in)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	for _, in := range []string{"", "GB", "-1GB", "10 parsecs", "100000000000000000000000000000TB", "9223372036854775808", "8EiB"} {
+		_, err := ParseSize(in)
+		assert.Error(t, err, in)
+	}
+}
+
+const testYAML = `
+window: monthly
+quotas:
+  - public_key: alice=
+    bytes: 50GiB
+  - labels:
+      team: contractors
+      region: eu
+    bytes: 5GB
+  - labels:
+      team: contractors
+    bytes: 10GB
+`
+
+func TestParseAndLookup(t *testing.T) {
+	c, err := Parse([]byte(testYAML), false)
+	require.NoError(t, err)
+	assert.Equal(t, Monthly, c.Window())
+	assert.True(t, c.HasGroups())
+
+	tests := []struct {
+		name   string
+		key    string
+		labels map[string]string
+		want   Size
+		ok     bool
+	}{
+		{"public key", "alice=", nil, 50 << 30, true},
+		{"public key wins over group", "alice=", map[string]string{"team": "contractors"}, 50 << 30, true},
+		{"first matching group", "bob=", map[string]string{"team": "contractors", "region": "eu"}, 5e9, true},
+		{"broader group", "carol=", map[string]string{"team": "contractors", "region": "us"}, 10e9, true},
+		{"no quota", "dave=", map[string]string{"team": "staff"}, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := c.Lookup(tt.key, tt.labels)
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestParseJSON(t *testing.T) {
+	c, err := Parse([]byte(`{"window": "daily", "quotas": [{"public_key": "alice=", "bytes": 1000}, {"public_key": "bob=", "bytes": "1KB"}]}`), true)
+	require.NoError(t, err)
+	assert.Equal(t, Daily, c.Window())
+	assert.False(t, c.HasGroups())
+
+	size, ok := c.Lookup("alice=", nil)
+	assert.True(t, ok)
+	assert.Equal(t, Size(1000), size)
+	size, _ = c.Lookup("bob=", nil)
+	assert.Equal(t, Size(1000), size)
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{"window", "window: weekly", `window must be "daily" or "monthly", got "weekly"`},
+		{"missing bytes", "quotas:\n  - public_key: alice=", "quota 1: bytes must be positive"},
+		{"no selector", "quotas:\n  - bytes: 1GB", "quota 1: exactly one of public_key and labels must be set"},
+		{"both selectors", "quotas:\n  - public_key: alice=\n    labels: {team: a}\n    bytes: 1GB", "quota 1: exactly one of public_key and labels must be set"},
+		{"duplicate", "quotas:\n  - public_key: alice=\n    bytes: 1GB\n  - public_key: alice=\n    bytes: 2GB", "quota 2: duplicate quota for peer alice="},
+		{"invalid size", "quotas:\n  - public_key: alice=\n    bytes: lots", `invalid size "lots"`},
+		{"unknown field", "quota: []", "field quota not found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse([]byte(tt.content), false)
+			assert.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestSourceReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "quotas.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("quotas:\n  - public_key: alice=\n    bytes: 1GB\n"), 0o600))
+
+	src, err := NewSource(path)
+	require.NoError(t, err)
+	size, _ := src.Config().Lookup("alice=", nil)
+	assert.Equal(t, Size(1e9), size)
+
+	require.NoError(t, os.WriteFile(path, []byte("quotas:\n  - public_key: alice=\n    bytes: 20GB\n"), 0o600))
+	future := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(path, future, future))
+	size, _ = src.Config().Lookup("alice=", nil)
+	assert.Equal(t, Size(20e9), size)
+
+	// A broken file keeps the previous quotas.
+	require.NoError(t, os.WriteFile(path, []byte("window: weekly\n"), 0o600))
+	future = future.Add(time.Minute)
+	require.NoError(t, os.Chtimes(path, future, future))
+	size, _ = src.Config().Lookup("alice=", nil)
+	assert.Equal(t, Size(20e9), size)
+
+	_, err = NewSource(filepath.Join(t.TempDir(), "missing.yaml"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,46 @@
+package wgprometheus
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/sathiraumesh/wireguard_exporter/internal/accounting"
+)
+
+// WithAccounting records the traffic of every peer into store. state
+// keeps the last counter values read, so that traffic moved across
+// interface resets and exporter restarts is still recorded. It is
+// separate from the state of WithCumulativeCounters and must not be
+// shared with it. state is saved whenever store writes its buckets, so
+// that both stay in step. A peer's traffic is recorded from the second
+// read after the state first sees it.
+func WithAccounting(store *accounting.Store, state *CounterState) Option {
+	return func(c *Collector) {
+		c.accounting = store
+		c.accountingState = state
+		state.manualSave = true
+		store.OnFlush(state.Save)
+	}
+}
+
+// recordTraffic records the bytes moved by each peer since the previous
+// device read.
+func (c *Collector) recordTraffic(at time.Time, samples peerSamples) {
+	if c.accounting == nil {
+		return
+	}
+	_, deltas := c.accountingState.update(samples.peers, samples.ifaces)
+	ifaces := samples.ifaces
+	usage := make(map[accounting.Peer]accounting.Usage, len(deltas))
+	for key, d := range deltas {
+		if d.transmit == 0 && d.receive == 0 {
+			continue
+		}
+		iface := ifaces[key.iface]
+		peer := accounting.Peer{Namespace: iface.namespace, Interface: iface.name, PublicKey: key.pubKey.String()}
+		usage[peer] = accounting.Usage{Transmit: d.transmit, Receive: d.receive}
+	}
+	if err := c.accounting.Record(at, usage); err != nil {
+		slog.Warn("failed to write traffic accounting", "error", err)
+	}
+}
@@ -0,0 +1,52 @@
+package wgprometheus
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sathiraumesh/wireguard_exporter/internal/accounting"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func TestCollectAccounting(t *testing.T) {
+	dir := t.TempDir()
+	peer := newTestPeer(1, 100, 200, time.Unix(1000, 0))
+	dev := &wgtypes.Device{Name: "wg0", Peers: []wgtypes.Peer{peer}}
+	lister := &mockDeviceLister{devices: []*wgtypes.Device{dev}}
+	statePath := filepath.Join(dir, "counters.json")
+
+	state, err := LoadCounterState(statePath)
+	require.NoError(t, err)
+	store, err := accounting.Open(dir)
+	require.NoError(t, err)
+	c := NewCollectorWithDevices(nil, lister, WithAccounting(store, state))
+
+	// The first read only establishes the baseline.
+	fm := familyMap(collectMetrics(t, c))
+	assert.NotContains(t, fm, "wireguard_peer_cumulative_transmit_bytes_total")
+	dev.Peers[0].TransmitBytes, dev.Peers[0].ReceiveBytes = 150, 230
+	collectMetrics(t, c)
+	// A reset counts the whole new value.
+	dev.Peers[0].TransmitBytes, dev.Peers[0].ReceiveBytes = 20, 230
+	collectMetrics(t, c)
+
+	// The state is written together with the buckets.
+	require.NoError(t, c.Close())
+	assert.NoFileExists(t, statePath)
+	require.NoError(t, store.Close())
+	assert.FileExists(t, statePath)
+
+	// After a restart, unchanged counters record nothing.
+	state, err = LoadCounterState(statePath)
+	require.NoError(t, err)
+	collectMetrics(t, NewCollectorWithDevices(nil, lister, WithAccounting(store, state)))
+
+	usage, err := store.Usage(accounting.Monthly, time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, map[accounting.Peer]accounting.Usage{
+		{Interface: "wg0", PublicKey: peer.PublicKey.String()}: {Transmit: 70, Receive: 30},
+	}, usage)
+}
@@ -0,0 +1,147 @@
+package wgprometheus
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+const (
+	benchInterfaces = 100
+	benchPeers      = 100
+	// benchReadLatency approximates a netlink dump of one interface with
+	// benchPeers peers.
+	benchReadLatency = time.Millisecond
+)
+
+// benchSizes are the interface and peer counts scrapes are benchmarked
+// with: the default device set and a 50k-peer hub.
+var benchSizes = []struct{ interfaces, peers int }{
+	{benchInterfaces, benchPeers},
+	{benchInterfaces, 5 * benchPeers},
+}
+
+// syntheticLister serves generated devices, sleeping for latency on every
+// read to stand in for the kernel round trip.
+type syntheticLister struct {
+	names   []string
+	devices map[string]*wgtypes.Device
+	latency time.Duration
+}
+
+func newSyntheticLister(interfaces, peers int, latency time.Duration) *syntheticLister {
+	l := &syntheticLister{devices: make(map[string]*wgtypes.Device, interfaces), latency: latency}
+	for i := range interfaces {
+		dev := &wgtypes.Device{Name: fmt.Sprintf("wg%d", i), Type: wgtypes.LinuxKernel}
+		for j := range peers {
+			var key wgtypes.Key
+			key[0], key[1], key[2] = byte(i), byte(j), byte(j>>8)
+			dev.Peers = append(dev.Peers, wgtypes.Peer{
+				PublicKey:         key,
+				Endpoint:          &net.UDPAddr{IP: net.IPv4(192, 0, 2, byte(j)), Port: 51820},
+				LastHandshakeTime: time.Now().Add(-time.Duration(j) * time.Second),
+				TransmitBytes:     int64(j) * 1000,
+				ReceiveBytes:      int64(j) * 2000,
+				AllowedIPs:        []net.IPNet{{IP: net.IPv4(10, byte(i), byte(j), 1), Mask: net.CIDRMask(32, 32)}},
+			})
+		}
+		l.names = append(l.names, dev.Name)
+		l.devices[dev.Name] = dev
+	}
+	return l
+}
+
+func (l *syntheticLister) DeviceNames(context.Context) ([]string, error) {
+	return l.names, nil
+}
+
+func (l *syntheticLister) Device(_ context.Context, name string) (*wgtypes.Device, error) {
+	time.Sleep(l.latency)
+	if dev, ok := l.devices[name]; ok {
+		return dev, nil
+	}
+	return nil, os.ErrNotExist
+}
+
+// drain runs one Collect and discards the metrics.
+func drain(c prometheus.Collector) {
+	ch := make(chan prometheus.Metric, 1024)
+	done := make(chan struct{})
+	go func() {
+		for range ch {
+		}
+		close(done)
+	}()
+	c.Collect(ch)
+	close(ch)
+	<-done
+}
+
+func BenchmarkCollectWorkers(b *testing.B) {
+	lister := newSyntheticLister(benchInterfaces, benchPeers, benchReadLatency)
+
+	for _, workers := range []int{1, 4, 16} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			c := NewCollectorWithInterfaces(nil, lister, WithWorkers(workers))
+			b.ResetTimer()
+			for range b.N {
+				drain(c)
+			}
+		})
+	}
+}
+
+func BenchmarkReadDevicesWorkers(b *testing.B) {
+	lister := newSyntheticLister(benchInterfaces, benchPeers, benchReadLatency)
+
+	for _, workers := range []int{1, 4, 16} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			for range b.N {
+				readDevices(context.Background(), lister, lister.names, nil, nil, workers)
+			}
+		})
+	}
+}
+
+func BenchmarkCollect(b *testing.B) {
+	for _, size := range benchSizes {
+		lister := newSyntheticLister(size.interfaces, size.peers, 0)
+
+		for _, mode := range []AllowedIPsMode{AllowedIPsLabel, AllowedIPsInfo} {
+			b.Run(fmt.Sprintf("peers=%d/allowed_ips=%s", size.interfaces*size.peers, mode), func(b *testing.B) {
+				c := NewCollectorWithInterfaces(nil, lister, WithAllowedIPsMode(mode))
+				drain(c)
+				b.ReportAllocs()
+				b.ResetTimer()
+				for range b.N {
+					drain(c)
+				}
+			})
+		}
+	}
+}
+
+// BenchmarkGather includes the registry's encoding of every metric, as
+// done for each /metrics request.
+func BenchmarkGather(b *testing.B) {
+	for _, size := range benchSizes {
+		b.Run(fmt.Sprintf("peers=%d", size.interfaces*size.peers), func(b *testing.B) {
+			lister := newSyntheticLister(size.interfaces, size.peers, 0)
+			reg := prometheus.NewRegistry()
+			reg.MustRegister(NewCollectorWithInterfaces(nil, lister))
+
+			b.ReportAllocs()
+			for range b.N {
+				if _, err := reg.Gather(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
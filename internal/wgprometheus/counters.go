@@ -0,0 +1,222 @@
+package wgprometheus
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sathiraumesh/wireguard_exporter/internal/atomicfile"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// counterSaveInterval is how often CounterState is written to disk while
+// the counters change.
+const counterSaveInterval = time.Minute
+
+// counterStateVersion is the version of the counter state file format.
+const counterStateVersion = 1
+
+// counterStateRetention is how long the counters of a peer that is no
+// longer read are kept.
+const counterStateRetention = 30 * 24 * time.Hour
+
+// WithCumulativeCounters exports byte counters that keep growing when the
+// WireGuard counters reset, for example on wg-quick down/up, with their
+// running totals kept in state.
+func WithCumulativeCounters(state *CounterState) Option {
+	return func(c *Collector) {
+		c.counters = state
+	}
+}
+
+// CounterState holds the cumulative byte counters of every peer read in
+// the last 30 days and persists them to a file, so that they also survive
+// exporter restarts. Peers are identified by their interface, network
+// namespace or container name, and public key; enabling namespace
+// scanning starts new counters, recreating a container does not.
+type CounterState struct {
+	path string
+
+	// manualSave is set when the owner of the state saves it, instead of
+	// update saving it every counterSaveInterval.
+	manualSave bool
+
+	mu    sync.Mutex
+	peers map[peerLabelKey]*peerCounters
+	dirty bool
+	saved time.Time
+}
+
+// peerCounters are the cumulative counters of one peer, last read at
+// lastSeen.
+type peerCounters struct {
+	ifaceValues []string
+	transmit    cumulativeCounter
+	receive     cumulativeCounter
+	lastSeen    time.Time
+}
+
+// cumulativeCounter follows a counter that may reset to zero. Last is the
+// latest raw value read, Total the bytes counted over all resets.
+type cumulativeCounter struct {
+	Total int64 `json:"total"`
+	Last  int64 `json:"last"`
+}
+
+// add accounts for the raw value cur. A value below the previous one
+// means the counter was reset, so all of cur is new.
+func (c *cumulativeCounter) add(cur int64) {
+	if cur < c.Last {
+		c.Total += cur
+	} else {
+		c.Total += cur - c.Last
+	}
+	c.Last = cur
+}
+
+// byteTotals are the cumulative counters of a peer as of one device read.
+type byteTotals struct {
+	transmit, receive int64
+}
+
+type counterStateFile struct {
+	Version int                `json:"version"`
+	Peers   []counterStatePeer `json:"peers"`
+}
+
+type counterStatePeer struct {
+	Interface []string          `json:"interface"`
+	PublicKey string            `json:"public_key"`
+	Transmit  cumulativeCounter `json:"transmit"`
+	Receive   cumulativeCounter `json:"receive"`
+	LastSeen  time.Time         `json:"last_seen"`
+}
+
+// LoadCounterState reads the counter state from path. A missing file
+// yields an empty state that is created on the first save. Peers saved
+// without a last read time count as read now.
+func LoadCounterState(path string) (*CounterState, error) {
+	s := &CounterState{path: path, peers: make(map[peerLabelKey]*peerCounters), saved: time.Now()}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var f counterStateFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("decoding counter state %s: %w", path, err)
+	}
+	if f.Version != counterStateVersion {
+		return nil, fmt.Errorf("counter state %s: unsupported version %d", path, f.Version)
+	}
+	for _, p := range f.Peers {
+		key, err := wgtypes.ParseKey(p.PublicKey)
+		if err != nil {
+			return nil, fmt.Errorf("counter state %s: peer %q: %w", path, p.PublicKey, err)
+		}
+		lastSeen := p.LastSeen
+		if lastSeen.IsZero() {
+			lastSeen = s.saved
+		}
+		s.peers[peerLabelKey{iface: strings.Join(p.Interface, "\xff"), pubKey: key}] = &peerCounters{
+			ifaceValues: p.Interface,
+			transmit:    p.Transmit,
+			receive:     p.Receive,
+			lastSeen:    lastSeen,
+		}
+	}
+	return s, nil
+}
+
+// Save writes the state to its file, replacing it atomically.
+func (s *CounterState) Save() error {
+	s.mu.Lock()
+	f := counterStateFile{Version: counterStateVersion, Peers: make([]counterStatePeer, 0, len(s.peers))}
+	for key, p := range s.peers {
+		f.Peers = append(f.Peers, counterStatePeer{
+			Interface: p.ifaceValues,
+			PublicKey: key.pubKey.String(),
+			Transmit:  p.transmit,
+			Receive:   p.receive,
+			LastSeen:  p.lastSeen,
+		})
+	}
+	s.dirty = false
+	s.saved = time.Now()
+	s.mu.Unlock()
+
+	slices.SortFunc(f.Peers, func(a, b counterStatePeer) int {
+		if c := slices.Compare(a.Interface, b.Interface); c != 0 {
+			return c
+		}
+		return strings.Compare(a.PublicKey, b.PublicKey)
+	})
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err == nil {
+		err = atomicfile.Write(s.path, data)
+	}
+	if err != nil {
+		// Keep the state dirty so that the next save retries.
+		s.mu.Lock()
+		s.dirty = true
+		s.mu.Unlock()
+	}
+	return err
+}
+
+// update accounts for the byte samples of a device read and returns the
+// cumulative counters of the sampled peers, and the bytes moved since the
+// previous read by the peers that were already known. Peers not read for
+// counterStateRetention are dropped. The state is saved once
+// counterSaveInterval has passed since the last save.
+func (s *CounterState) update(samples map[peerLabelKey]byteSample, ifaces map[string]sampledInterface) (totals, deltas map[peerLabelKey]byteTotals) {
+	now := time.Now()
+	s.mu.Lock()
+	totals = make(map[peerLabelKey]byteTotals, len(samples))
+	deltas = make(map[peerLabelKey]byteTotals, len(samples))
+	for key, sample := range samples {
+		values := ifaces[key.iface].stateValues
+		stateKey := peerLabelKey{iface: strings.Join(values, "\xff"), pubKey: key.pubKey}
+		p, ok := s.peers[stateKey]
+		if !ok {
+			p = &peerCounters{ifaceValues: values}
+			s.peers[stateKey] = p
+		}
+		if !ok || sample.transmit != p.transmit.Last || sample.receive != p.receive.Last {
+			s.dirty = true
+		}
+		p.lastSeen = now
+		prev := byteTotals{transmit: p.transmit.Total, receive: p.receive.Total}
+		p.transmit.add(sample.transmit)
+		p.receive.add(sample.receive)
+		totals[key] = byteTotals{transmit: p.transmit.Total, receive: p.receive.Total}
+		if ok {
+			deltas[key] = byteTotals{transmit: p.transmit.Total - prev.transmit, receive: p.receive.Total - prev.receive}
+		}
+	}
+	for key, p := range s.peers {
+		if now.Sub(p.lastSeen) > counterStateRetention {
+			delete(s.peers, key)
+			s.dirty = true
+		}
+	}
+	due := s.dirty && !s.manualSave && time.Since(s.saved) >= counterSaveInterval
+	s.mu.Unlock()
+
+	if due {
+		if err := s.Save(); err != nil {
+			slog.Warn("failed to save counter state", "path", s.path, "error", err)
+		}
+	}
+	return totals, deltas
+}
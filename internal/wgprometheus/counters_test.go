@@ -0,0 +1,145 @@
+package wgprometheus
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func TestCumulativeCounterAdd(t *testing.T) {
+	var c cumulativeCounter
+	for _, v := range []int64{100, 250, 250, 30, 80, 0, 5} {
+		c.add(v)
+	}
+	// 250 before the first reset, 80 before the second, then 5.
+	assert.Equal(t, int64(335), c.Total)
+	assert.Equal(t, int64(5), c.Last)
+}
+
+func cumulativeValues(t *testing.T, c *Collector) (transmit, receive float64) {
+	t.Helper()
+	fm := familyMap(collectMetrics(t, c))
+	require.Contains(t, fm, "wireguard_peer_cumulative_transmit_bytes_total")
+	require.Contains(t, fm, "wireguard_peer_cumulative_receive_bytes_total")
+	return fm["wireguard_peer_cumulative_transmit_bytes_total"].GetMetric()[0].GetCounter().GetValue(),
+		fm["wireguard_peer_cumulative_receive_bytes_total"].GetMetric()[0].GetCounter().GetValue()
+}
+
+func TestCollectCumulativeCounters(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "counters.json")
+	dev := &wgtypes.Device{Name: "wg0", Peers: []wgtypes.Peer{newTestPeer(1, 100, 200, time.Unix(1000, 0))}}
+	lister := &mockDeviceLister{devices: []*wgtypes.Device{dev}}
+
+	state, err := LoadCounterState(path)
+	require.NoError(t, err)
+	c := NewCollectorWithDevices(nil, lister, WithCumulativeCounters(state))
+
+	tx, rx := cumulativeValues(t, c)
+	assert.Equal(t, 100.0, tx)
+	assert.Equal(t, 200.0, rx)
+
+	// wg-quick down/up resets the WireGuard counters.
+	dev.Peers[0].TransmitBytes, dev.Peers[0].ReceiveBytes = 40, 10
+	tx, rx = cumulativeValues(t, c)
+	assert.Equal(t, 140.0, tx)
+	assert.Equal(t, 210.0, rx)
+	require.NoError(t, c.Close())
+
+	// A restarted exporter continues from the saved totals.
+	state, err = LoadCounterState(path)
+	require.NoError(t, err)
+	c = NewCollectorWithDevices(nil, lister, WithCumulativeCounters(state))
+	dev.Peers[0].TransmitBytes = 60
+	tx, rx = cumulativeValues(t, c)
+	assert.Equal(t, 160.0, tx)
+	assert.Equal(t, 210.0, rx)
+}
+
+func TestCollectWithoutCumulativeCounters(t *testing.T) {
+	c := NewCollectorWithDevices(nil, &mockDeviceLister{devices: []*wgtypes.Device{
+		{Name: "wg0", Peers: []wgtypes.Peer{newTestPeer(1, 100, 200, time.Unix(1000, 0))}},
+	}})
+	fm := familyMap(collectMetrics(t, c))
+	assert.NotContains(t, fm, "wireguard_peer_cumulative_transmit_bytes_total")
+}
+
+func TestLoadCounterState(t *testing.T) {
+	dir := t.TempDir()
+
+	state, err := LoadCounterState(filepath.Join(dir, "missing.json"))
+	require.NoError(t, err)
+	assert.Empty(t, state.peers)
+
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{"invalid JSON", "{", "decoding counter state"},
+		{"unknown version", `{"version": 2}`, "unsupported version 2"},
+		{"invalid key", `{"version": 1, "peers": [{"interface": ["wg0"], "public_key": "nope"}]}`, `peer "nope"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, "state.json")
+			require.NoError(t, os.WriteFile(path, []byte(tt.content), 0o600))
+			_, err := LoadCounterState(path)
+			assert.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestCounterStateSaveFailureStaysDirty(t *testing.T) {
+	state, err := LoadCounterState(filepath.Join(t.TempDir(), "missing", "counters.json"))
+	require.NoError(t, err)
+	key := peerLabelKey{iface: "wg0", pubKey: wgtypes.Key{1}}
+	ifaces := map[string]sampledInterface{"wg0": {stateValues: []string{"wg0"}}}
+	state.update(map[peerLabelKey]byteSample{key: {transmit: 1}}, ifaces)
+
+	assert.Error(t, state.Save())
+	assert.True(t, state.dirty)
+}
+
+func TestCounterStateDropsAbsentPeers(t *testing.T) {
+	state, err := LoadCounterState(filepath.Join(t.TempDir(), "counters.json"))
+	require.NoError(t, err)
+	ifaces := map[string]sampledInterface{"wg0": {stateValues: []string{"wg0"}}}
+	gone := peerLabelKey{iface: "wg0", pubKey: wgtypes.Key{1}}
+	kept := peerLabelKey{iface: "wg0", pubKey: wgtypes.Key{2}}
+	state.update(map[peerLabelKey]byteSample{gone: {transmit: 1}, kept: {transmit: 1}}, ifaces)
+
+	state.peers[gone].lastSeen = time.Now().Add(-counterStateRetention - time.Hour)
+	state.update(map[peerLabelKey]byteSample{kept: {transmit: 2}}, ifaces)
+	assert.NotContains(t, state.peers, gone)
+	assert.Contains(t, state.peers, kept)
+}
+
+func TestCollectCumulativeCountersSurviveContainerRecreation(t *testing.T) {
+	state, err := LoadCounterState(filepath.Join(t.TempDir(), "counters.json"))
+	require.NoError(t, err)
+	peer := newTestPeer(1, 100, 200, time.Unix(1000, 0))
+	lister := &mockNamespaceLister{}
+	c := NewCollectorWithDevices(nil, &mockDeviceLister{},
+		WithNamespaceLister(lister), WithContainerLabels(), WithCumulativeCounters(state))
+
+	scrape := func(id, netns string, transmit int64) float64 {
+		peer.TransmitBytes = transmit
+		lister.devices = []NamespacedDevice{{
+			Namespace:     netns,
+			ContainerID:   id,
+			ContainerName: "vpn",
+			Name:          "wg0",
+			Device:        &wgtypes.Device{Name: "wg0", Peers: []wgtypes.Peer{peer}},
+		}}
+		tx, _ := cumulativeValues(t, c)
+		return tx
+	}
+	assert.Equal(t, 100.0, scrape("aaa", "/proc/10/ns/net", 100))
+	// The recreated container starts with fresh WireGuard counters.
+	assert.Equal(t, 130.0, scrape("bbb", "/proc/20/ns/net", 30))
+}
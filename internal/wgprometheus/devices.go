@@ -0,0 +1,338 @@
+package wgprometheus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/sync/errgroup"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// DefaultWorkers is the default number of interfaces read concurrently.
+const DefaultWorkers = 4
+
+// WithWorkers sets how many interfaces are read concurrently. Values below
+// one are treated as one.
+func WithWorkers(n int) Option {
+	return func(c *Collector) {
+		c.workers = n
+	}
+}
+
+// DefaultScrapeTimeout is the default time a device read may take.
+const DefaultScrapeTimeout = 10 * time.Second
+
+// WithScrapeTimeout sets the longest time a device read may take, whatever
+// the deadline of the scrape that started it. Values of zero or below
+// leave reads bounded by the scrape deadline only.
+func WithScrapeTimeout(d time.Duration) Option {
+	return func(c *Collector) {
+		c.scrapeTimeout = d
+	}
+}
+
+// Error classes of the wireguard_interface_scrape_errors_total class label.
+const (
+	errorClassPermissionDenied = "permission_denied"
+	errorClassNotFound         = "not_found"
+	errorClassTimeout          = "timeout"
+	errorClassOther            = "other"
+)
+
+// InterfaceLister reads WireGuard interfaces one at a time, so that one
+// failing interface does not hide the others. Implementations return
+// ctx.Err() once ctx is done instead of blocking the scrape.
+type InterfaceLister interface {
+	// DeviceNames returns the names of the WireGuard interfaces. Names
+	// that are gone by the time they are read are skipped when Device
+	// reports os.ErrNotExist for them.
+	DeviceNames(ctx context.Context) ([]string, error)
+	Device(ctx context.Context, name string) (*wgtypes.Device, error)
+}
+
+// deviceReader reads a single WireGuard device by name.
+type deviceReader interface {
+	Device(ctx context.Context, name string) (*wgtypes.Device, error)
+}
+
+// FromDeviceLister adapts a DeviceLister to InterfaceLister. DeviceNames
+// lists all devices in one call, abandoning it when ctx is done, and
+// Device serves the devices of the latest listing. While an abandoned call
+// is still running, DeviceNames fails with a timeout instead of starting
+// another.
+func FromDeviceLister(l DeviceLister) InterfaceLister {
+	return &deviceListerAdapter{lister: l}
+}
+
+type deviceListerAdapter struct {
+	lister DeviceLister
+	// listing is set while a call to lister.Devices is in flight.
+	listing atomic.Bool
+
+	mu      sync.Mutex
+	devices map[string]*wgtypes.Device
+}
+
+func (a *deviceListerAdapter) DeviceNames(ctx context.Context) ([]string, error) {
+	if !a.listing.CompareAndSwap(false, true) {
+		return nil, errStillRunning
+	}
+	devices, err := callContext(ctx, func() ([]*wgtypes.Device, error) {
+		defer a.listing.Store(false)
+		return a.lister.Devices()
+	})
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(devices))
+	byName := make(map[string]*wgtypes.Device, len(devices))
+	for _, dev := range devices {
+		names = append(names, dev.Name)
+		byName[dev.Name] = dev
+	}
+
+	a.mu.Lock()
+	a.devices = byName
+	a.mu.Unlock()
+	return names, nil
+}
+
+func (a *deviceListerAdapter) Device(_ context.Context, name string) (*wgtypes.Device, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if dev, ok := a.devices[name]; ok {
+		return dev, nil
+	}
+	return nil, os.ErrNotExist
+}
+
+// errStillRunning is returned instead of reading an interface whose
+// previous read was abandoned at a deadline and has not returned yet, so
+// that a wedged read does not pile up another goroutine on every scrape.
+var errStillRunning = fmt.Errorf("previous read has not returned: %w", os.ErrDeadlineExceeded)
+
+// pendingCalls tracks the keys with a call in flight.
+type pendingCalls struct {
+	mu      sync.Mutex
+	running map[string]struct{}
+}
+
+// start marks key as in flight, reporting false if it already was.
+func (p *pendingCalls) start(key string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, ok := p.running[key]; ok {
+		return false
+	}
+	if p.running == nil {
+		p.running = make(map[string]struct{})
+	}
+	p.running[key] = struct{}{}
+	return true
+}
+
+// done marks the call for key as returned.
+func (p *pendingCalls) done(key string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.running, key)
+}
+
+// callContext runs fn on its own goroutine and returns its result, or
+// ctx.Err() if ctx is done first. An abandoned fn keeps running until it
+// returns on its own.
+func callContext[T any](ctx context.Context, fn func() (T, error)) (T, error) {
+	type result struct {
+		v   T
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		v, err := fn()
+		done <- result{v, err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.v, r.err
+	case <-ctx.Done():
+		var zero T
+		return zero, ctx.Err()
+	}
+}
+
+// readDevices reads each name that passes monitor, or every name if
+// monitor is nil, using up to workers concurrent reads. Results keep the
+// order of names. Names that turn out not to be WireGuard interfaces are
+// skipped, unless listed in required, in which case they are reported as
+// not found. Once ctx is done the remaining names are reported with
+// ctx.Err() without being read.
+func readDevices(ctx context.Context, r deviceReader, names []string, required map[string]struct{}, monitor func(string) bool, workers int) []NamespacedDevice {
+	var todo []string
+	for _, name := range names {
+		if monitor == nil || monitor(name) {
+			todo = append(todo, name)
+		}
+	}
+
+	results := make([]NamespacedDevice, len(todo))
+	var g errgroup.Group
+	g.SetLimit(max(workers, 1))
+	for i, name := range todo {
+		g.Go(func() error {
+			results[i] = readDevice(ctx, r, name)
+			return nil
+		})
+	}
+	g.Wait()
+
+	return slices.DeleteFunc(results, func(nd NamespacedDevice) bool {
+		_, ok := required[nd.Name]
+		return errors.Is(nd.Err, os.ErrNotExist) && !ok
+	})
+}
+
+func readDevice(ctx context.Context, r deviceReader, name string) NamespacedDevice {
+	if err := ctx.Err(); err != nil {
+		return NamespacedDevice{Name: name, Err: err, unread: true}
+	}
+	start := time.Now()
+	dev, err := r.Device(ctx, name)
+	return NamespacedDevice{Name: name, Device: dev, Err: err, Duration: time.Since(start)}
+}
+
+// withRequiredNames appends the explicitly monitored interfaces missing
+// from names, so that their absence is reported.
+func (c *Collector) withRequiredNames(names []string) []string {
+	missing := make([]string, 0, len(c.monitorSet))
+	for name := range c.monitorSet {
+		if !slices.Contains(names, name) {
+			missing = append(missing, name)
+		}
+	}
+	slices.Sort(missing)
+	return append(names, missing...)
+}
+
+// errorClass maps an interface read error to a coarse class for the error
+// counter.
+func errorClass(err error) string {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, os.ErrPermission):
+		return errorClassPermissionDenied
+	case errors.Is(err, os.ErrNotExist):
+		return errorClassNotFound
+	case errors.Is(err, os.ErrDeadlineExceeded), errors.Is(err, context.DeadlineExceeded):
+		return errorClassTimeout
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return errorClassTimeout
+	default:
+		return errorClassOther
+	}
+}
+
+// scrapeErrorCount is the value of one wireguard_interface_scrape_errors_total
+// series. iface is the joined label values of its interface.
+type scrapeErrorCount struct {
+	iface  string
+	values []string
+	count  float64
+}
+
+// recordErrors counts the failed interface reads among devices.
+func (c *Collector) recordErrors(devices []NamespacedDevice) {
+	c.errMu.Lock()
+	defer c.errMu.Unlock()
+
+	for _, nd := range devices {
+		if nd.Err == nil || !c.shouldMonitor(nd.Name) {
+			continue
+		}
+		class := errorClass(nd.Err)
+		slog.Warn("failed to read WireGuard interface", "interface", nd.Name, "netns", nd.Namespace, "class", class, "error", nd.Err)
+
+		ifaceValues := c.interfaceValues(nd)
+		iface := strings.Join(ifaceValues, "\xff")
+		values := append(ifaceValues, class)
+		key := strings.Join(values, "\xff")
+		e, ok := c.scrapeErrors[key]
+		if !ok {
+			e = &scrapeErrorCount{iface: iface, values: values}
+			c.scrapeErrors[key] = e
+		}
+		e.count++
+	}
+}
+
+// forgetInterfaces drops the error counters and the known state of
+// interfaces missing from devices, a complete listing, so that removed
+// interfaces do not keep their series forever.
+func (c *Collector) forgetInterfaces(devices []NamespacedDevice) {
+	present := make(map[string]struct{}, len(devices))
+	for _, nd := range devices {
+		present[strings.Join(c.interfaceValues(nd), "\xff")] = struct{}{}
+	}
+
+	c.errMu.Lock()
+	for key, e := range c.scrapeErrors {
+		if _, ok := present[e.iface]; !ok {
+			delete(c.scrapeErrors, key)
+		}
+	}
+	c.errMu.Unlock()
+
+	c.knownMu.Lock()
+	for key := range c.knownIfaces {
+		if _, ok := present[key]; !ok {
+			delete(c.knownIfaces, key)
+		}
+	}
+	c.knownMu.Unlock()
+}
+
+// dropUnknownUnread removes the interfaces whose read never started
+// because the deadline passed first, unless they have been read as
+// WireGuard devices before or were listed explicitly. A lister may list
+// names that are not WireGuard interfaces, and those must never be
+// reported as timed out.
+func (c *Collector) dropUnknownUnread(devices []NamespacedDevice) []NamespacedDevice {
+	c.knownMu.Lock()
+	defer c.knownMu.Unlock()
+
+	return slices.DeleteFunc(devices, func(nd NamespacedDevice) bool {
+		key := strings.Join(c.interfaceValues(nd), "\xff")
+		if nd.Device != nil {
+			c.knownIfaces[key] = struct{}{}
+		}
+		if !nd.unread {
+			return false
+		}
+		if _, ok := c.monitorSet[nd.Name]; ok {
+			return false
+		}
+		_, known := c.knownIfaces[key]
+		return !known
+	})
+}
+
+// collectErrors emits the interface read error counters.
+func (c *Collector) collectErrors(ch chan<- prometheus.Metric) {
+	c.errMu.Lock()
+	defer c.errMu.Unlock()
+
+	for _, e := range c.scrapeErrors {
+		ch <- prometheus.MustNewConstMetric(c.ifaceDescs.scrapeErrors, prometheus.CounterValue, e.count, e.values...)
+	}
+}
@@ -0,0 +1,366 @@
+package wgprometheus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// mockInterfaceLister reads devices one at a time. Names without a device
+// or error behave like non-WireGuard interfaces.
+type mockInterfaceLister struct {
+	mockDeviceLister
+	names  []string
+	errors map[string]error
+}
+
+func (m *mockInterfaceLister) DeviceNames(context.Context) ([]string, error) {
+	return m.names, nil
+}
+
+func (m *mockInterfaceLister) Device(_ context.Context, name string) (*wgtypes.Device, error) {
+	if err, ok := m.errors[name]; ok {
+		return nil, err
+	}
+	for _, dev := range m.devices {
+		if dev.Name == name {
+			return dev, nil
+		}
+	}
+	return nil, os.ErrNotExist
+}
+
+func metricByLabel(metrics []*dto.Metric, name, value string) *dto.Metric {
+	for _, m := range metrics {
+		if labelMap(m)[name] == value {
+			return m
+		}
+	}
+	return nil
+}
+
+func TestCollectPartialFailure(t *testing.T) {
+	lister := &mockInterfaceLister{
+		mockDeviceLister: mockDeviceLister{
+			devices: []*wgtypes.Device{
+				{Name: "wg0", Peers: []wgtypes.Peer{newTestPeer(1, 100, 200, time.Unix(1000, 0))}},
+			},
+		},
+		names:  []string{"lo", "eth0", "wg0", "wg1"},
+		errors: map[string]error{"wg1": fmt.Errorf("reading wg1: %w", syscall.EACCES)},
+	}
+	c := NewCollectorWithInterfaces(nil, lister)
+	fm := familyMap(collectMetrics(t, c))
+
+	assert.Equal(t, 1.0, fm["wireguard_scrape_success"].GetMetric()[0].GetGauge().GetValue())
+
+	// wg0 is exported despite wg1 failing; lo and eth0 are not WireGuard.
+	require.Contains(t, fm, "wireguard_peer_up")
+	assert.Len(t, fm["wireguard_peer_up"].GetMetric(), 1)
+	assert.Len(t, fm["wireguard_interface_info"].GetMetric(), 1)
+
+	success := fm["wireguard_interface_scrape_success"].GetMetric()
+	require.Len(t, success, 2)
+	assert.Equal(t, 1.0, metricByLabel(success, "interface", "wg0").GetGauge().GetValue())
+	assert.Equal(t, 0.0, metricByLabel(success, "interface", "wg1").GetGauge().GetValue())
+	assert.Len(t, fm["wireguard_interface_scrape_duration_seconds"].GetMetric(), 2)
+
+	errs := fm["wireguard_interface_scrape_errors_total"].GetMetric()
+	require.Len(t, errs, 1)
+	assert.Equal(t, map[string]string{"interface": "wg1", "class": "permission_denied"}, labelMap(errs[0]))
+	assert.Equal(t, 1.0, errs[0].GetCounter().GetValue())
+
+	// The counter keeps growing across scrapes.
+	fm = familyMap(collectMetrics(t, c))
+	assert.Equal(t, 2.0, fm["wireguard_interface_scrape_errors_total"].GetMetric()[0].GetCounter().GetValue())
+}
+
+func TestCollectMissingMonitoredInterface(t *testing.T) {
+	lister := &mockInterfaceLister{
+		mockDeviceLister: mockDeviceLister{devices: []*wgtypes.Device{{Name: "wg0"}}},
+		names:            []string{"eth0", "wg0"},
+	}
+	c := NewCollectorWithInterfaces([]string{"wg0", "wg9"}, lister)
+	fm := familyMap(collectMetrics(t, c))
+
+	success := fm["wireguard_interface_scrape_success"].GetMetric()
+	require.Len(t, success, 2)
+	assert.Equal(t, 0.0, metricByLabel(success, "interface", "wg9").GetGauge().GetValue())
+
+	errs := fm["wireguard_interface_scrape_errors_total"].GetMetric()
+	require.Len(t, errs, 1)
+	assert.Equal(t, map[string]string{"interface": "wg9", "class": "not_found"}, labelMap(errs[0]))
+}
+
+func TestCollectEveryReadFailed(t *testing.T) {
+	lister := &mockInterfaceLister{
+		names:  []string{"wg0", "wg1"},
+		errors: map[string]error{"wg0": syscall.EPERM, "wg1": syscall.EPERM},
+	}
+	c := NewCollectorWithInterfaces(nil, lister)
+	fm := familyMap(collectMetrics(t, c))
+
+	assert.Equal(t, 0.0, fm["wireguard_scrape_success"].GetMetric()[0].GetGauge().GetValue())
+	assert.Len(t, fm["wireguard_interface_scrape_success"].GetMetric(), 2)
+
+	// Without any WireGuard interface there is nothing to fail.
+	lister.names = nil
+	fm = familyMap(collectMetrics(t, c))
+	assert.Equal(t, 1.0, fm["wireguard_scrape_success"].GetMetric()[0].GetGauge().GetValue())
+}
+
+func TestCollectForgetsErrorsOfRemovedInterfaces(t *testing.T) {
+	lister := &mockInterfaceLister{
+		mockDeviceLister: mockDeviceLister{devices: []*wgtypes.Device{{Name: "wg0"}}},
+		names:            []string{"wg0", "wg1"},
+		errors:           map[string]error{"wg1": syscall.EPERM},
+	}
+	c := NewCollectorWithInterfaces(nil, lister)
+	fm := familyMap(collectMetrics(t, c))
+	require.Len(t, fm["wireguard_interface_scrape_errors_total"].GetMetric(), 1)
+
+	// A recovered interface keeps its counter, a removed one loses it.
+	delete(lister.errors, "wg1")
+	lister.devices = append(lister.devices, &wgtypes.Device{Name: "wg1"})
+	fm = familyMap(collectMetrics(t, c))
+	require.Len(t, fm["wireguard_interface_scrape_errors_total"].GetMetric(), 1)
+
+	lister.names = []string{"wg0"}
+	fm = familyMap(collectMetrics(t, c))
+	assert.NotContains(t, fm, "wireguard_interface_scrape_errors_total")
+}
+
+func TestCollectSkipsUnmonitoredReads(t *testing.T) {
+	lister := &mockInterfaceLister{
+		mockDeviceLister: mockDeviceLister{devices: []*wgtypes.Device{{Name: "wg0"}}},
+		names:            []string{"wg0", "wg1"},
+		errors:           map[string]error{"wg1": errors.New("broken")},
+	}
+	c := NewCollectorWithInterfaces([]string{"wg0"}, lister)
+	fm := familyMap(collectMetrics(t, c))
+
+	assert.Len(t, fm["wireguard_interface_scrape_success"].GetMetric(), 1)
+	assert.NotContains(t, fm, "wireguard_interface_scrape_errors_total")
+}
+
+func TestErrorClass(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"EPERM", syscall.EPERM, "permission_denied"},
+		{"wrapped EACCES", fmt.Errorf("netlink: %w", syscall.EACCES), "permission_denied"},
+		{"not exist", os.ErrNotExist, "not_found"},
+		{"deadline", os.ErrDeadlineExceeded, "timeout"},
+		{"context deadline", context.DeadlineExceeded, "timeout"},
+		{"ETIMEDOUT", syscall.ETIMEDOUT, "timeout"},
+		{"still running", errStillRunning, "timeout"},
+		{"other", errors.New("boom"), "other"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, errorClass(tt.err))
+		})
+	}
+}
+
+// blockingInterfaceLister blocks reads of the names in block until ctx is
+// done, and records every name it was asked to read.
+type blockingInterfaceLister struct {
+	mockInterfaceLister
+	block map[string]bool
+	read  []string
+}
+
+func (m *blockingInterfaceLister) Device(ctx context.Context, name string) (*wgtypes.Device, error) {
+	m.read = append(m.read, name)
+	if m.block[name] {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	return m.mockInterfaceLister.Device(ctx, name)
+}
+
+func TestCollectWithContextTimeout(t *testing.T) {
+	lister := &blockingInterfaceLister{
+		mockInterfaceLister: mockInterfaceLister{
+			mockDeviceLister: mockDeviceLister{
+				devices: []*wgtypes.Device{
+					{Name: "wg0", Peers: []wgtypes.Peer{newTestPeer(1, 100, 200, time.Unix(1000, 0))}},
+					{Name: "wg1"},
+					{Name: "wg2"},
+				},
+			},
+			names: []string{"wg0", "wg1", "wg2", "lo"},
+		},
+	}
+	c := NewCollectorWithInterfaces(nil, lister, WithWorkers(1))
+	collectMetrics(t, c)
+
+	lister.block = map[string]bool{"wg1": true}
+	lister.read = nil
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	fm := familyMap(collectMetrics(t, c.WithContext(ctx)))
+
+	assert.Equal(t, 1.0, fm["wireguard_scrape_success"].GetMetric()[0].GetGauge().GetValue())
+	assert.Equal(t, 1.0, fm["wireguard_scrape_timed_out"].GetMetric()[0].GetGauge().GetValue())
+
+	// wg0 was read before the deadline; wg2 and lo are never attempted.
+	assert.Equal(t, []string{"wg0", "wg1"}, lister.read)
+	assert.Len(t, fm["wireguard_peer_up"].GetMetric(), 1)
+
+	// wg2 is known from the previous scrape, lo was never a WireGuard
+	// interface and is left out.
+	success := fm["wireguard_interface_scrape_success"].GetMetric()
+	require.Len(t, success, 3)
+	assert.Equal(t, 1.0, metricByLabel(success, "interface", "wg0").GetGauge().GetValue())
+	assert.Equal(t, 0.0, metricByLabel(success, "interface", "wg1").GetGauge().GetValue())
+	assert.Equal(t, 0.0, metricByLabel(success, "interface", "wg2").GetGauge().GetValue())
+
+	errs := fm["wireguard_interface_scrape_errors_total"].GetMetric()
+	require.Len(t, errs, 2)
+	for _, m := range errs {
+		assert.Equal(t, "timeout", labelMap(m)["class"])
+	}
+}
+
+func TestCollectTimeoutSkipsUnknownInterfaces(t *testing.T) {
+	newLister := func() *blockingInterfaceLister {
+		return &blockingInterfaceLister{
+			mockInterfaceLister: mockInterfaceLister{
+				mockDeviceLister: mockDeviceLister{devices: []*wgtypes.Device{{Name: "wg0"}, {Name: "wg1"}}},
+				names:            []string{"wg0", "lo", "eth0", "wg1"},
+			},
+			block: map[string]bool{"wg0": true},
+		}
+	}
+	scrape := func(c *Collector) []*dto.Metric {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		return familyMap(collectMetrics(t, c.WithContext(ctx)))["wireguard_interface_scrape_success"].GetMetric()
+	}
+
+	// Before any interface has been read, unread names are left out...
+	success := scrape(NewCollectorWithInterfaces(nil, newLister(), WithWorkers(1)))
+	require.Len(t, success, 1)
+	assert.NotNil(t, metricByLabel(success, "interface", "wg0"))
+
+	// ...unless they were listed with -i.
+	success = scrape(NewCollectorWithInterfaces([]string{"wg0", "wg1"}, newLister(), WithWorkers(1)))
+	require.Len(t, success, 2)
+	assert.NotNil(t, metricByLabel(success, "interface", "wg1"))
+}
+
+func TestDeviceListerAdapterTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	lister := &countingDeviceLister{release: release}
+	c := NewCollectorWithDevices(nil, lister)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	fm := familyMap(collectMetrics(t, c.WithContext(ctx)))
+
+	assert.Less(t, time.Since(start), time.Second)
+	assert.Equal(t, 0.0, fm["wireguard_scrape_success"].GetMetric()[0].GetGauge().GetValue())
+	assert.Equal(t, 1.0, fm["wireguard_scrape_timed_out"].GetMetric()[0].GetGauge().GetValue())
+}
+
+func TestDeviceListerAdapterSkipsWhileListing(t *testing.T) {
+	release := make(chan struct{})
+	lister := &countingDeviceLister{release: release, devices: []*wgtypes.Device{{Name: "wg0"}}}
+	a := FromDeviceLister(lister)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err := a.DeviceNames(ctx)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// The abandoned call has not returned, so no second one is started.
+	_, err = a.DeviceNames(context.Background())
+	assert.ErrorIs(t, err, errStillRunning)
+	assert.Equal(t, int32(1), lister.calls.Load())
+
+	close(release)
+	require.Eventually(t, func() bool {
+		names, err := a.DeviceNames(context.Background())
+		return err == nil && len(names) == 1
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, int32(2), lister.calls.Load())
+}
+
+func TestPendingCalls(t *testing.T) {
+	var p pendingCalls
+	require.True(t, p.start("wg0"))
+	assert.False(t, p.start("wg0"))
+	assert.True(t, p.start("wg1"))
+
+	p.done("wg0")
+	assert.True(t, p.start("wg0"))
+}
+
+func TestCollectWithoutTimeout(t *testing.T) {
+	c := NewCollectorWithDevices(nil, &mockDeviceLister{devices: []*wgtypes.Device{{Name: "wg0"}}})
+	fm := familyMap(collectMetrics(t, c))
+
+	assert.Equal(t, 0.0, fm["wireguard_scrape_timed_out"].GetMetric()[0].GetGauge().GetValue())
+}
+
+// concurrencyInterfaceLister records the peak number of concurrent reads.
+type concurrencyInterfaceLister struct {
+	mockInterfaceLister
+	inflight atomic.Int32
+	peak     atomic.Int32
+}
+
+func (m *concurrencyInterfaceLister) Device(ctx context.Context, name string) (*wgtypes.Device, error) {
+	n := m.inflight.Add(1)
+	defer m.inflight.Add(-1)
+	for {
+		peak := m.peak.Load()
+		if n <= peak || m.peak.CompareAndSwap(peak, n) {
+			break
+		}
+	}
+	time.Sleep(5 * time.Millisecond)
+	return m.mockInterfaceLister.Device(ctx, name)
+}
+
+func TestReadDevicesBoundedParallel(t *testing.T) {
+	var names []string
+	var devices []*wgtypes.Device
+	for i := range 12 {
+		name := fmt.Sprintf("wg%d", i)
+		names = append(names, name, "eth"+name)
+		devices = append(devices, &wgtypes.Device{Name: name})
+	}
+	lister := &concurrencyInterfaceLister{
+		mockInterfaceLister: mockInterfaceLister{
+			mockDeviceLister: mockDeviceLister{devices: devices},
+			names:            names,
+		},
+	}
+
+	result := readDevices(context.Background(), lister, names, nil, nil, 3)
+
+	require.Len(t, result, 12)
+	for i, nd := range result {
+		assert.Equal(t, fmt.Sprintf("wg%d", i), nd.Name)
+		assert.NoError(t, nd.Err)
+	}
+	assert.Equal(t, int32(3), lister.peak.Load())
+}
@@ -0,0 +1,181 @@
+package wgprometheus
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"path"
+	"regexp"
+	"strings"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// regexPrefix marks a NamePattern as a regular expression instead of a glob.
+const regexPrefix = "re:"
+
+// NamePattern matches interface names. Patterns are shell globs such as
+// "wg-site-*" unless prefixed with "re:", in which case the rest is a
+// regular expression that must match the whole name.
+type NamePattern struct {
+	raw  string
+	glob string
+	re   *regexp.Regexp
+}
+
+// ParseNamePattern validates and compiles a pattern.
+func ParseNamePattern(pattern string) (NamePattern, error) {
+	pattern = strings.TrimSpace(pattern)
+	if pattern == "" {
+		return NamePattern{}, fmt.Errorf("empty pattern")
+	}
+
+	if expr, ok := strings.CutPrefix(pattern, regexPrefix); ok {
+		if expr == "" {
+			return NamePattern{}, fmt.Errorf("empty regular expression")
+		}
+		re, err := regexp.Compile("^(?:" + expr + ")$")
+		if err != nil {
+			return NamePattern{}, err
+		}
+		return NamePattern{raw: pattern, re: re}, nil
+	}
+
+	if _, err := path.Match(pattern, ""); err != nil {
+		return NamePattern{}, err
+	}
+	return NamePattern{raw: pattern, glob: pattern}, nil
+}
+
+// Match reports whether name matches the pattern.
+func (p NamePattern) Match(name string) bool {
+	if p.re != nil {
+		return p.re.MatchString(name)
+	}
+	ok, _ := path.Match(p.glob, name)
+	return ok
+}
+
+func (p NamePattern) String() string {
+	return p.raw
+}
+
+// WithInterfaceInclude monitors interfaces matching any of patterns in
+// addition to those named explicitly.
+func WithInterfaceInclude(patterns []NamePattern) Option {
+	return func(c *Collector) {
+		c.includePatterns = patterns
+	}
+}
+
+// WithInterfaceExclude skips interfaces matching any of patterns, even if
+// they are named explicitly or match an include pattern.
+func WithInterfaceExclude(patterns []NamePattern) Option {
+	return func(c *Collector) {
+		c.excludePatterns = patterns
+	}
+}
+
+// shouldMonitor applies the interface filters: excludes win, then the
+// interface must be named explicitly or match an include pattern. With no
+// names and no include patterns every interface is included.
+func (c *Collector) shouldMonitor(name string) bool {
+	if matchAny(c.excludePatterns, name) {
+		return false
+	}
+	if len(c.monitorSet) == 0 && len(c.includePatterns) == 0 {
+		return true
+	}
+	if _, ok := c.monitorSet[name]; ok {
+		return true
+	}
+	return matchAny(c.includePatterns, name)
+}
+
+func matchAny(patterns []NamePattern, name string) bool {
+	for _, p := range patterns {
+		if p.Match(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// WithPeerAllowlist only exports peers whose public key is in keys.
+func WithPeerAllowlist(keys []wgtypes.Key) Option {
+	return func(c *Collector) {
+		c.peerAllow = keySet(keys)
+	}
+}
+
+// WithPeerDenylist never exports peers whose public key is in keys.
+func WithPeerDenylist(keys []wgtypes.Key) Option {
+	return func(c *Collector) {
+		c.peerDeny = keySet(keys)
+	}
+}
+
+// WithPeerCIDRs only exports peers with at least one allowed IP prefix
+// overlapping one of prefixes.
+func WithPeerCIDRs(prefixes []netip.Prefix) Option {
+	return func(c *Collector) {
+		c.peerCIDRs = prefixes
+	}
+}
+
+// shouldExportPeer applies the peer filters: the denylist wins, then the
+// peer must pass every configured allowlist and CIDR filter.
+func (c *Collector) shouldExportPeer(peer *wgtypes.Peer) bool {
+	if _, denied := c.peerDeny[peer.PublicKey]; denied {
+		return false
+	}
+	if len(c.peerAllow) > 0 {
+		if _, ok := c.peerAllow[peer.PublicKey]; !ok {
+			return false
+		}
+	}
+	if len(c.peerCIDRs) > 0 && !overlapsAny(peer.AllowedIPs, c.peerCIDRs) {
+		return false
+	}
+	return true
+}
+
+func overlapsAny(allowedIPs []net.IPNet, prefixes []netip.Prefix) bool {
+	for _, ipNet := range allowedIPs {
+		p, ok := ipNetToPrefix(ipNet)
+		if !ok {
+			continue
+		}
+		for _, q := range prefixes {
+			if p.Overlaps(q) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ipNetToPrefix converts ipNet, unmapping IPv4 addresses stored in
+// 16-byte form.
+func ipNetToPrefix(ipNet net.IPNet) (netip.Prefix, bool) {
+	addr, ok := netip.AddrFromSlice(ipNet.IP)
+	if !ok {
+		return netip.Prefix{}, false
+	}
+	ones, bits := ipNet.Mask.Size()
+	if bits == 0 {
+		return netip.Prefix{}, false
+	}
+	if addr.Is4In6() && bits == 32 {
+		addr = addr.Unmap()
+	}
+	return netip.PrefixFrom(addr, ones), true
+}
+
+func keySet(keys []wgtypes.Key) map[wgtypes.Key]struct{} {
+	set := make(map[wgtypes.Key]struct{}, len(keys))
+	for _, k := range keys {
+		set[k] = struct{}{}
+	}
+	return set
+}
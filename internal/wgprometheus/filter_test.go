@@ -0,0 +1,251 @@
+package wgprometheus
+
+import (
+	"net"
+	"net/netip"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sathiraumesh/wireguard_exporter/internal/accounting"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func mustPatterns(t *testing.T, patterns ...string) []NamePattern {
+	t.Helper()
+	parsed := make([]NamePattern, 0, len(patterns))
+	for _, p := range patterns {
+		np, err := ParseNamePattern(p)
+		require.NoError(t, err)
+		parsed = append(parsed, np)
+	}
+	return parsed
+}
+
+func TestNamePatternMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		match   bool
+	}{
+		{pattern: "wg-site-*", name: "wg-site-ams", match: true},
+		{pattern: "wg-site-*", name: "wg-client-1", match: false},
+		{pattern: "wg?", name: "wg0", match: true},
+		{pattern: `re:wg-client-\d+`, name: "wg-client-1234", match: true},
+		{pattern: `re:wg-client-\d+`, name: "wg-client-1234x", match: false},
+		{pattern: `re:client`, name: "wg-client-1", match: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.name, func(t *testing.T) {
+			p := mustPatterns(t, tt.pattern)[0]
+			assert.Equal(t, tt.match, p.Match(tt.name))
+		})
+	}
+}
+
+func TestParseNamePatternErrors(t *testing.T) {
+	for _, pattern := range []string{"", "wg[", "re:wg(", "re:"} {
+		t.Run(pattern, func(t *testing.T) {
+			_, err := ParseNamePattern(pattern)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestShouldMonitor(t *testing.T) {
+	tests := []struct {
+		name     string
+		keys     []string
+		opts     []Option
+		expected map[string]bool
+	}{
+		{
+			name:     "everything by default",
+			expected: map[string]bool{"wg0": true, "wg-test": true},
+		},
+		{
+			name:     "exact names only",
+			keys:     []string{"wg0"},
+			expected: map[string]bool{"wg0": true, "wg1": false},
+		},
+		{
+			name: "include patterns add to exact names",
+			keys: []string{"wg0"},
+			opts: []Option{WithInterfaceInclude(mustPatterns(t, "wg-site-*"))},
+			expected: map[string]bool{
+				"wg0": true, "wg-site-ams": true, "wg1": false,
+			},
+		},
+		{
+			name: "exclude only monitors everything else",
+			opts: []Option{WithInterfaceExclude(mustPatterns(t, "wg-test*"))},
+			expected: map[string]bool{
+				"wg0": true, "wg-test": false, "wg-test-2": false,
+			},
+		},
+		{
+			name: "exclude wins over include and exact names",
+			keys: []string{"wg-test"},
+			opts: []Option{
+				WithInterfaceInclude(mustPatterns(t, `re:wg-.*`)),
+				WithInterfaceExclude(mustPatterns(t, "wg-test")),
+			},
+			expected: map[string]bool{
+				"wg-test": false, "wg-prod": true, "eth0": false,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewCollectorWithDevices(tt.keys, &mockDeviceLister{}, tt.opts...)
+			for iface, expected := range tt.expected {
+				assert.Equal(t, expected, c.shouldMonitor(iface), iface)
+			}
+		})
+	}
+}
+
+func TestCollectWithInterfacePatterns(t *testing.T) {
+	mock := &mockDeviceLister{
+		devices: []*wgtypes.Device{
+			{Name: "wg-client-1", Peers: []wgtypes.Peer{newTestPeer(1, 100, 200, time.Unix(1000, 0))}},
+			{Name: "wg-client-2", Peers: []wgtypes.Peer{newTestPeer(2, 100, 200, time.Unix(1000, 0))}},
+			{Name: "wg-test", Peers: []wgtypes.Peer{newTestPeer(3, 100, 200, time.Unix(1000, 0))}},
+		},
+	}
+
+	c := NewCollectorWithDevices(nil, mock,
+		WithInterfaceInclude(mustPatterns(t, "wg-client-*", "wg-test")),
+		WithInterfaceExclude(mustPatterns(t, `re:.*-2`)),
+	)
+	fm := familyMap(collectMetrics(t, c))
+
+	require.Contains(t, fm, "wireguard_interface_info")
+	var names []string
+	for _, metric := range fm["wireguard_interface_info"].GetMetric() {
+		names = append(names, labelMap(metric)["interface"])
+	}
+	assert.ElementsMatch(t, []string{"wg-client-1", "wg-test"}, names)
+}
+
+func TestShouldExportPeer(t *testing.T) {
+	peer1 := newTestPeer(1, 0, 0, time.Time{}) // 10.0.0.1/32
+	peer2 := newTestPeer(2, 0, 0, time.Time{})
+	peer2.AllowedIPs = []net.IPNet{{IP: net.ParseIP("fd00::5"), Mask: net.CIDRMask(128, 128)}}
+	peer3 := newTestPeer(3, 0, 0, time.Time{})
+	peer3.AllowedIPs = nil
+
+	tests := []struct {
+		name     string
+		opts     []Option
+		expected []bool
+	}{
+		{
+			name:     "no filters",
+			expected: []bool{true, true, true},
+		},
+		{
+			name:     "allowlist",
+			opts:     []Option{WithPeerAllowlist([]wgtypes.Key{peer1.PublicKey, peer3.PublicKey})},
+			expected: []bool{true, false, true},
+		},
+		{
+			name:     "denylist",
+			opts:     []Option{WithPeerDenylist([]wgtypes.Key{peer2.PublicKey})},
+			expected: []bool{true, false, true},
+		},
+		{
+			name: "denylist wins over allowlist",
+			opts: []Option{
+				WithPeerAllowlist([]wgtypes.Key{peer1.PublicKey, peer2.PublicKey}),
+				WithPeerDenylist([]wgtypes.Key{peer1.PublicKey}),
+			},
+			expected: []bool{false, true, false},
+		},
+		{
+			name:     "ipv4 supernet",
+			opts:     []Option{WithPeerCIDRs([]netip.Prefix{netip.MustParsePrefix("10.0.0.0/24")})},
+			expected: []bool{true, false, false},
+		},
+		{
+			name:     "ipv6 prefix",
+			opts:     []Option{WithPeerCIDRs([]netip.Prefix{netip.MustParsePrefix("fd00::/64")})},
+			expected: []bool{false, true, false},
+		},
+		{
+			name: "allowlist and CIDRs must both match",
+			opts: []Option{
+				WithPeerAllowlist([]wgtypes.Key{peer1.PublicKey, peer2.PublicKey}),
+				WithPeerCIDRs([]netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}),
+			},
+			expected: []bool{true, false, false},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewCollectorWithDevices(nil, &mockDeviceLister{}, tt.opts...)
+			for i, peer := range []wgtypes.Peer{peer1, peer2, peer3} {
+				assert.Equal(t, tt.expected[i], c.shouldExportPeer(&peer), "peer %d", i+1)
+			}
+		})
+	}
+}
+
+func TestCollectWithPeerFilters(t *testing.T) {
+	peer1 := newTestPeer(1, 100, 200, time.Unix(1000, 0))
+	peer2 := newTestPeer(2, 100, 200, time.Unix(1000, 0))
+
+	mock := &mockDeviceLister{
+		devices: []*wgtypes.Device{
+			{Name: "wg0", Peers: []wgtypes.Peer{peer1, peer2}},
+		},
+	}
+
+	c := NewCollectorWithDevices(nil, mock, WithPeerDenylist([]wgtypes.Key{peer2.PublicKey}))
+	fm := familyMap(collectMetrics(t, c))
+
+	require.Contains(t, fm, "wireguard_peer_up")
+	metrics := fm["wireguard_peer_up"].GetMetric()
+	require.Equal(t, 1, len(metrics))
+	assert.Equal(t, peer1.PublicKey.String(), labelMap(metrics[0])["public_key"])
+	assert.Equal(t, 1.0, fm["wireguard_interface_peers"].GetMetric()[0].GetGauge().GetValue())
+}
+
+func TestFilteredPeersAreNotTracked(t *testing.T) {
+	dir := t.TempDir()
+	peer1 := newTestPeer(1, 100, 200, time.Unix(1000, 0))
+	peer2 := newTestPeer(2, 100, 200, time.Unix(1000, 0))
+	dev := &wgtypes.Device{Name: "wg0", Peers: []wgtypes.Peer{peer1, peer2}}
+
+	counters, err := LoadCounterState(filepath.Join(dir, "counters.json"))
+	require.NoError(t, err)
+	billing, err := LoadCounterState(filepath.Join(dir, "accounting", "counters.json"))
+	require.NoError(t, err)
+	store, err := accounting.Open(filepath.Join(dir, "accounting"))
+	require.NoError(t, err)
+	c := NewCollectorWithDevices(nil, &mockDeviceLister{devices: []*wgtypes.Device{dev}},
+		WithPeerDenylist([]wgtypes.Key{peer2.PublicKey}), WithCumulativeCounters(counters),
+		WithAccounting(store, billing), WithPeerSessions())
+
+	collectMetrics(t, c)
+	dev.Peers[0].TransmitBytes, dev.Peers[1].TransmitBytes = 150, 150
+	collectMetrics(t, c)
+
+	denied := peerLabelKey{iface: "wg0", pubKey: peer2.PublicKey}
+	assert.Len(t, counters.peers, 1)
+	assert.NotContains(t, counters.peers, denied)
+	assert.Len(t, billing.peers, 1)
+	assert.NotContains(t, c.rates.prev, denied)
+	assert.NotContains(t, c.sessions.peers, denied)
+
+	usage, err := store.Usage(accounting.Monthly, time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, map[accounting.Peer]accounting.Usage{
+		{Interface: "wg0", PublicKey: peer1.PublicKey.String()}: {Transmit: 50},
+	}, usage)
+}
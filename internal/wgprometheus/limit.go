@@ -0,0 +1,131 @@
+package wgprometheus
+
+import (
+	"cmp"
+	"slices"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// PeerLimitMode selects what happens to per-peer series when a peer limit
+// is exceeded.
+type PeerLimitMode string
+
+const (
+	// PeerLimitDrop emits no per-peer series for the peers over the limit:
+	// all peers of an interface over its limit, or all peers once the
+	// total limit is exceeded.
+	PeerLimitDrop PeerLimitMode = "drop"
+	// PeerLimitTop emits the peers with the most traffic up to the limit.
+	PeerLimitTop PeerLimitMode = "top"
+)
+
+// WithMaxPeersPerInterface limits the peers with per-peer series on each
+// interface. Zero disables the limit.
+func WithMaxPeersPerInterface(n int) Option {
+	return func(c *Collector) {
+		c.maxPeersPerInterface = n
+	}
+}
+
+// WithMaxTotalPeers limits the peers with per-peer series across all
+// interfaces. It applies after the per-interface limit. Zero disables the
+// limit.
+func WithMaxTotalPeers(n int) Option {
+	return func(c *Collector) {
+		c.maxTotalPeers = n
+	}
+}
+
+// WithPeerLimitMode selects how the peer limits truncate. It defaults to
+// PeerLimitDrop.
+func WithPeerLimitMode(mode PeerLimitMode) Option {
+	return func(c *Collector) {
+		c.peerLimitMode = mode
+	}
+}
+
+// deviceScrape is a device read in this scrape together with the peers
+// that pass the peer filters. keep marks the peers whose series are
+// emitted; nil keeps all of them.
+type deviceScrape struct {
+	dev         *wgtypes.Device
+	namespace   string
+	ifaceValues []string
+	peers       []*wgtypes.Peer
+	keep        []bool
+	truncated   int
+}
+
+// peerRef is a peer of a deviceScrape, by index.
+type peerRef struct {
+	d *deviceScrape
+	i int
+}
+
+func (r peerRef) traffic() int64 {
+	p := r.d.peers[r.i]
+	return p.TransmitBytes + p.ReceiveBytes
+}
+
+// kept reports whether the peer at index i has its series emitted.
+func (d *deviceScrape) kept(i int) bool {
+	return d.keep == nil || d.keep[i]
+}
+
+func (d *deviceScrape) drop(i int) {
+	if d.keep == nil {
+		d.keep = make([]bool, len(d.peers))
+		for j := range d.keep {
+			d.keep[j] = true
+		}
+	}
+	d.keep[i] = false
+	d.truncated++
+}
+
+// keptRefs returns the peers of d whose series are still emitted.
+func (d *deviceScrape) keptRefs() []peerRef {
+	refs := make([]peerRef, 0, len(d.peers))
+	for i := range d.peers {
+		if d.kept(i) {
+			refs = append(refs, peerRef{d: d, i: i})
+		}
+	}
+	return refs
+}
+
+// applyPeerLimits marks the peers whose series are dropped by the
+// per-interface and total peer limits.
+func (c *Collector) applyPeerLimits(devs []*deviceScrape) {
+	if c.maxPeersPerInterface > 0 {
+		for _, d := range devs {
+			c.limitPeers(d.keptRefs(), c.maxPeersPerInterface)
+		}
+	}
+	if c.maxTotalPeers > 0 {
+		var refs []peerRef
+		for _, d := range devs {
+			refs = append(refs, d.keptRefs()...)
+		}
+		c.limitPeers(refs, c.maxTotalPeers)
+	}
+}
+
+// limitPeers drops peers from refs when there are more than limit: all of
+// them in drop mode, or all but the limit with the most traffic in top
+// mode. Ties keep the device and peer order.
+func (c *Collector) limitPeers(refs []peerRef, limit int) {
+	if len(refs) <= limit {
+		return
+	}
+	if c.peerLimitMode == PeerLimitTop {
+		slices.SortStableFunc(refs, func(a, b peerRef) int {
+			return cmp.Compare(b.traffic(), a.traffic())
+		})
+		refs = refs[limit:]
+	}
+	for _, r := range refs {
+		r.d.drop(r.i)
+	}
+}
@@ -0,0 +1,111 @@
+package wgprometheus
+
+import (
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// limitDevices returns wg0 with peers 1-3 and wg1 with peers 4-5; a
+// peer's traffic grows with its key byte, except peer 1, the busiest.
+func limitDevices() []*wgtypes.Device {
+	now := time.Now()
+	return []*wgtypes.Device{
+		{Name: "wg0", Peers: []wgtypes.Peer{
+			newTestPeer(1, 9000, 9000, now),
+			newTestPeer(2, 200, 0, now),
+			newTestPeer(3, 300, 0, now),
+		}},
+		{Name: "wg1", Peers: []wgtypes.Peer{
+			newTestPeer(4, 400, 0, now),
+			newTestPeer(5, 500, 0, time.Time{}),
+		}},
+	}
+}
+
+// exportedKeys returns the first key byte of every peer with a
+// wireguard_peer_up series, by interface.
+func exportedKeys(fm map[string]*dto.MetricFamily) map[string][]byte {
+	keys := make(map[string][]byte)
+	for _, m := range fm["wireguard_peer_up"].GetMetric() {
+		labels := labelMap(m)
+		key, _ := wgtypes.ParseKey(labels["public_key"])
+		keys[labels["interface"]] = append(keys[labels["interface"]], key[0])
+	}
+	return keys
+}
+
+func interfaceGauge(t *testing.T, fm map[string]*dto.MetricFamily, name, iface string) float64 {
+	t.Helper()
+	m := metricByLabel(fm[name].GetMetric(), "interface", iface)
+	require.NotNil(t, m, "%s{interface=%q}", name, iface)
+	return m.GetGauge().GetValue()
+}
+
+func TestPeerLimits(t *testing.T) {
+	tests := []struct {
+		name      string
+		opts      []Option
+		want      map[string][]byte
+		truncated map[string]float64
+	}{
+		{
+			name:      "no limits",
+			want:      map[string][]byte{"wg0": {1, 2, 3}, "wg1": {4, 5}},
+			truncated: map[string]float64{"wg0": 0, "wg1": 0},
+		},
+		{
+			name:      "per interface drop",
+			opts:      []Option{WithMaxPeersPerInterface(2)},
+			want:      map[string][]byte{"wg1": {4, 5}},
+			truncated: map[string]float64{"wg0": 3, "wg1": 0},
+		},
+		{
+			name:      "per interface top",
+			opts:      []Option{WithMaxPeersPerInterface(2), WithPeerLimitMode(PeerLimitTop)},
+			want:      map[string][]byte{"wg0": {1, 3}, "wg1": {4, 5}},
+			truncated: map[string]float64{"wg0": 1, "wg1": 0},
+		},
+		{
+			name:      "total drop",
+			opts:      []Option{WithMaxTotalPeers(4)},
+			want:      map[string][]byte{},
+			truncated: map[string]float64{"wg0": 3, "wg1": 2},
+		},
+		{
+			name:      "total top",
+			opts:      []Option{WithMaxTotalPeers(2), WithPeerLimitMode(PeerLimitTop)},
+			want:      map[string][]byte{"wg0": {1}, "wg1": {5}},
+			truncated: map[string]float64{"wg0": 2, "wg1": 1},
+		},
+		{
+			name:      "per interface then total",
+			opts:      []Option{WithMaxPeersPerInterface(2), WithMaxTotalPeers(3), WithPeerLimitMode(PeerLimitTop)},
+			want:      map[string][]byte{"wg0": {1}, "wg1": {4, 5}},
+			truncated: map[string]float64{"wg0": 2, "wg1": 0},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewCollectorWithDevices(nil, &mockDeviceLister{devices: limitDevices()}, tt.opts...)
+			fm := familyMap(collectMetrics(t, c))
+
+			assert.Equal(t, tt.want, exportedKeys(fm))
+			for iface, want := range tt.truncated {
+				assert.Equal(t, want, interfaceGauge(t, fm, "wireguard_exporter_peers_truncated", iface), iface)
+			}
+
+			// Aggregates always cover every peer.
+			assert.Equal(t, 3.0, interfaceGauge(t, fm, "wireguard_interface_peers", "wg0"))
+			assert.Equal(t, 3.0, interfaceGauge(t, fm, "wireguard_interface_peers_up", "wg0"))
+			assert.Equal(t, 2.0, interfaceGauge(t, fm, "wireguard_interface_peers", "wg1"))
+			assert.Equal(t, 1.0, interfaceGauge(t, fm, "wireguard_interface_peers_up", "wg1"))
+			assert.Equal(t, 1.0, interfaceGauge(t, fm, "wireguard_interface_peers_never_handshaked", "wg1"))
+		})
+	}
+}
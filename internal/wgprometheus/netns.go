@@ -0,0 +1,77 @@
+package wgprometheus
+
+import (
+	"context"
+	"os"
+	"slices"
+	"time"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// NamespacedDevice is the result of reading one WireGuard interface,
+// together with the network namespace it was found in. Namespace is empty
+// for the exporter's own namespace. The container fields are set when the
+// namespace belongs to a container and the lister resolves containers.
+// Device is nil when the read failed with Err.
+type NamespacedDevice struct {
+	Namespace     string
+	ContainerID   string
+	ContainerName string
+	Name          string
+	Device        *wgtypes.Device
+	Err           error
+	Duration      time.Duration
+
+	// unread is set when the deadline passed before the read started.
+	unread bool
+}
+
+// NamespaceLister lists the WireGuard devices selected by filter across
+// network namespaces, returning what it has read so far once ctx is done.
+type NamespaceLister interface {
+	NamespacedDevices(ctx context.Context, filter DeviceFilter) ([]NamespacedDevice, error)
+}
+
+// DeviceFilter selects the interfaces a NamespaceLister reads. Interfaces
+// for which Monitor returns false are not read; a nil Monitor reads every
+// interface. Required interfaces that no namespace has are reported as
+// not found in the exporter's own namespace.
+type DeviceFilter struct {
+	Monitor  func(name string) bool
+	Required map[string]struct{}
+}
+
+// missing returns a not found result for every required interface
+// missing from devices.
+func (f DeviceFilter) missing(devices []NamespacedDevice) []NamespacedDevice {
+	names := make([]string, 0, len(f.Required))
+	for name := range f.Required {
+		if !slices.ContainsFunc(devices, func(nd NamespacedDevice) bool { return nd.Name == name }) {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	missing := make([]NamespacedDevice, 0, len(names))
+	for _, name := range names {
+		missing = append(missing, NamespacedDevice{Name: name, Err: os.ErrNotExist})
+	}
+	return missing
+}
+
+// WithNamespaceLister collects devices from every namespace l reports
+// instead of only the exporter's own, and adds a netns label to all
+// interface and peer metrics.
+func WithNamespaceLister(l NamespaceLister) Option {
+	return func(c *Collector) {
+		c.namespaces = l
+	}
+}
+
+// WithContainerLabels adds container and container_id labels to all
+// interface and peer metrics, taken from the NamespacedDevice fields.
+func WithContainerLabels() Option {
+	return func(c *Collector) {
+		c.containerLabels = true
+	}
+}
@@ -0,0 +1,281 @@
+package wgprometheus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"syscall"
+
+	"github.com/sathiraumesh/wireguard_exporter/internal/container"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/sys/unix"
+	"golang.zx2c4.com/wireguard/wgctrl"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// DefaultNetnsDir is where iproute2 bind-mounts named network namespaces.
+const DefaultNetnsDir = "/run/netns"
+
+// selfNetns is the network namespace of the calling thread.
+const selfNetns = "/proc/thread-self/ns/net"
+
+// NetnsLister lists WireGuard devices in the exporter's own network
+// namespace, in every named namespace under Dir, and in the namespaces at
+// Paths, such as /proc/<pid>/ns/net. Each namespace is scanned once even if
+// it is reachable through several paths.
+//
+// Userspace devices are discovered through UAPI sockets on the filesystem
+// rather than through the namespace, so they are only reported for the
+// exporter's own namespace.
+//
+// When Containers is set, every namespace is resolved to the container
+// owning it. Workers bounds both the concurrent interface reads in the
+// exporter's own namespace and the number of namespaces scanned at once.
+// A namespace whose scan outlived a deadline is skipped until the scan
+// returns.
+type NetnsLister struct {
+	Dir        string
+	Paths      []string
+	Containers *container.Resolver
+	Workers    int
+
+	own wgDeviceLister
+	// pending holds the namespaces whose abandoned scan is still running.
+	pending pendingCalls
+}
+
+// NewNetnsLister returns a NetnsLister scanning dir and paths.
+func NewNetnsLister(dir string, paths []string) *NetnsLister {
+	return &NetnsLister{Dir: dir, Paths: paths, Workers: DefaultWorkers}
+}
+
+// Close releases the wgctrl clients of the exporter's own namespace.
+func (l *NetnsLister) Close() error {
+	return l.own.Close()
+}
+
+// netnsTarget is a namespace to scan, labelled by name.
+type netnsTarget struct {
+	name string
+	path string
+}
+
+func (l *NetnsLister) NamespacedDevices(ctx context.Context, filter DeviceFilter) ([]NamespacedDevice, error) {
+	return l.namespacedDevices(ctx, &l.own, filter)
+}
+
+// namespacedDevices lists the devices of the exporter's own namespace
+// through own and those of the other namespaces through their own wgctrl
+// clients.
+func (l *NetnsLister) namespacedDevices(ctx context.Context, own InterfaceLister, filter DeviceFilter) ([]NamespacedDevice, error) {
+	names, err := own.DeviceNames(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ownDevices := readDevices(ctx, own, names, nil, filter.Monitor, l.Workers)
+	targets, err := l.targets()
+	if err != nil {
+		return nil, err
+	}
+
+	var owners map[string]container.Info
+	if l.Containers != nil {
+		paths := []string{selfNetns}
+		for _, t := range targets {
+			paths = append(paths, t.path)
+		}
+		owners = l.Containers.Resolve(paths)
+	}
+
+	result := make([]NamespacedDevice, 0, len(ownDevices))
+	for _, nd := range ownDevices {
+		result = append(result, withContainer(nd, owners[selfNetns]))
+	}
+
+	perTarget := make([][]NamespacedDevice, len(targets))
+	var g errgroup.Group
+	g.SetLimit(max(l.Workers, 1))
+	for i, t := range targets {
+		g.Go(func() error {
+			if ctx.Err() != nil {
+				return nil
+			}
+			if !l.pending.start(t.path) {
+				slog.Warn("skipping network namespace", "netns", t.name, "error", errStillRunning)
+				return nil
+			}
+			devices, err := devicesInNetns(ctx, t.path, filter.Monitor, func() { l.pending.done(t.path) })
+			if err != nil {
+				slog.Warn("failed to list WireGuard devices in network namespace", "netns", t.name, "error", err)
+				return nil
+			}
+			perTarget[i] = devices
+			return nil
+		})
+	}
+	g.Wait()
+
+	for i, t := range targets {
+		for _, nd := range perTarget[i] {
+			if nd.Device != nil && nd.Device.Type == wgtypes.Userspace {
+				continue
+			}
+			nd.Namespace = t.name
+			result = append(result, withContainer(nd, owners[t.path]))
+		}
+	}
+	for _, nd := range filter.missing(result) {
+		result = append(result, withContainer(nd, owners[selfNetns]))
+	}
+	return result, nil
+}
+
+func withContainer(nd NamespacedDevice, owner container.Info) NamespacedDevice {
+	nd.ContainerID = owner.ID
+	nd.ContainerName = owner.Name
+	return nd
+}
+
+// targets returns the namespaces to scan besides the exporter's own, with
+// duplicates removed by namespace inode.
+func (l *NetnsLister) targets() ([]netnsTarget, error) {
+	seen := make(map[nsID]struct{})
+	if id, err := netnsID(selfNetns); err == nil {
+		seen[id] = struct{}{}
+	}
+
+	var candidates []netnsTarget
+	if l.Dir != "" {
+		entries, err := os.ReadDir(l.Dir)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("reading %s: %w", l.Dir, err)
+		}
+		for _, e := range entries {
+			candidates = append(candidates, netnsTarget{name: e.Name(), path: filepath.Join(l.Dir, e.Name())})
+		}
+		sort.Slice(candidates, func(i, j int) bool { return candidates[i].name < candidates[j].name })
+	}
+	for _, p := range l.Paths {
+		candidates = append(candidates, netnsTarget{name: p, path: p})
+	}
+
+	var targets []netnsTarget
+	for _, t := range candidates {
+		id, err := netnsID(t.path)
+		if err != nil {
+			slog.Warn("skipping network namespace", "netns", t.name, "error", err)
+			continue
+		}
+		if _, ok := seen[id]; ok {
+			continue
+		}
+		seen[id] = struct{}{}
+		targets = append(targets, t)
+	}
+	return targets, nil
+}
+
+// nsID identifies a namespace by the device and inode of its nsfs file.
+type nsID struct {
+	dev uint64
+	ino uint64
+}
+
+func netnsID(path string) (nsID, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nsID{}, err
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nsID{}, fmt.Errorf("%s: unsupported file info", path)
+	}
+	return nsID{dev: uint64(st.Dev), ino: uint64(st.Ino)}, nil
+}
+
+// devicesInNetns reads the WireGuard devices in the network namespace at
+// path that pass monitor, one interface at a time. The work runs on a dedicated, locked OS
+// thread: the wgctrl netlink socket is bound to the namespace the thread
+// is in when the socket is opened. When ctx is done first the thread is
+// abandoned and finishes its current read in the background. finished is
+// called once the thread is done, which may be after devicesInNetns
+// returned.
+func devicesInNetns(ctx context.Context, path string, monitor func(string) bool, finished func()) ([]NamespacedDevice, error) {
+	type result struct {
+		devices []NamespacedDevice
+		err     error
+	}
+	done := make(chan result, 1)
+
+	go func() {
+		devices, err := readNetns(ctx, path, monitor)
+		finished()
+		done <- result{devices: devices, err: err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.devices, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// readNetns enters the network namespace at path on the calling thread,
+// reads its devices that pass monitor and returns to the original namespace. If the thread
+// cannot return, it is left locked so the runtime discards it when the
+// calling goroutine exits.
+func readNetns(ctx context.Context, path string, monitor func(string) bool) ([]NamespacedDevice, error) {
+	runtime.LockOSThread()
+
+	orig, err := os.Open(selfNetns)
+	if err != nil {
+		runtime.UnlockOSThread()
+		return nil, err
+	}
+	defer orig.Close()
+
+	target, err := os.Open(path)
+	if err != nil {
+		runtime.UnlockOSThread()
+		return nil, err
+	}
+	defer target.Close()
+
+	if err := unix.Setns(int(target.Fd()), unix.CLONE_NEWNET); err != nil {
+		runtime.UnlockOSThread()
+		return nil, fmt.Errorf("entering network namespace: %w", err)
+	}
+
+	var devices []NamespacedDevice
+	names, err := kernelDeviceNames()
+	if err == nil {
+		var client *wgctrl.Client
+		client, err = wgctrl.New()
+		if err == nil {
+			devices = readDevices(ctx, clientReader{client}, names, nil, monitor, 1)
+			client.Close()
+		}
+	}
+
+	if restoreErr := unix.Setns(int(orig.Fd()), unix.CLONE_NEWNET); restoreErr != nil {
+		return nil, fmt.Errorf("restoring network namespace: %w", restoreErr)
+	}
+	runtime.UnlockOSThread()
+	return devices, err
+}
+
+// clientReader reads devices with a wgctrl client owned by one goroutine,
+// which checks the context between reads.
+type clientReader struct {
+	client *wgctrl.Client
+}
+
+func (r clientReader) Device(_ context.Context, name string) (*wgtypes.Device, error) {
+	return r.client.Device(name)
+}
@@ -0,0 +1,65 @@
+package wgprometheus
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func TestNetnsListerTargets(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"tenant-b", "tenant-a"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), nil, 0o600))
+	}
+	extra := filepath.Join(t.TempDir(), "container")
+	require.NoError(t, os.WriteFile(extra, nil, 0o600))
+
+	l := NewNetnsLister(dir, []string{
+		filepath.Join(dir, "tenant-a"), // same namespace as a named one
+		extra,
+		selfNetns, // the exporter's own namespace is always scanned separately
+		filepath.Join(dir, "missing"),
+	})
+	targets, err := l.targets()
+	require.NoError(t, err)
+
+	var names []string
+	for _, target := range targets {
+		names = append(names, target.name)
+	}
+	assert.Equal(t, []string{"tenant-a", "tenant-b", extra}, names)
+}
+
+func TestNetnsListerTargetsMissingDir(t *testing.T) {
+	l := NewNetnsLister(filepath.Join(t.TempDir(), "netns"), nil)
+	targets, err := l.targets()
+	require.NoError(t, err)
+	assert.Empty(t, targets)
+}
+
+func TestNetnsListerFilter(t *testing.T) {
+	own := &blockingInterfaceLister{
+		mockInterfaceLister: mockInterfaceLister{
+			mockDeviceLister: mockDeviceLister{devices: []*wgtypes.Device{{Name: "wg0"}, {Name: "wg1"}}},
+			names:            []string{"wg0", "wg1"},
+		},
+	}
+	l := NewNetnsLister("", nil)
+	c := NewCollectorWithDevices([]string{"wg0", "wg9"}, &mockDeviceLister{}, WithNamespaceLister(l))
+
+	devices, err := l.namespacedDevices(context.Background(), own, DeviceFilter{Monitor: c.shouldMonitor, Required: c.monitorSet})
+	require.NoError(t, err)
+
+	// wg1 is not listed with -i and is never read; wg9 is nowhere.
+	assert.Equal(t, []string{"wg0"}, own.read)
+	require.Len(t, devices, 2)
+	assert.Equal(t, "wg0", devices[0].Name)
+	assert.NoError(t, devices[0].Err)
+	assert.Equal(t, "wg9", devices[1].Name)
+	assert.ErrorIs(t, devices[1].Err, os.ErrNotExist)
+}
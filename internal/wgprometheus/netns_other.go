@@ -0,0 +1,31 @@
+//go:build !linux
+
+package wgprometheus
+
+import (
+	"context"
+	"errors"
+
+	"github.com/sathiraumesh/wireguard_exporter/internal/container"
+)
+
+// DefaultNetnsDir is where iproute2 bind-mounts named network namespaces.
+const DefaultNetnsDir = "/run/netns"
+
+// NetnsLister lists WireGuard devices across network namespaces. It is
+// only supported on Linux.
+type NetnsLister struct {
+	Dir        string
+	Paths      []string
+	Containers *container.Resolver
+	Workers    int
+}
+
+// NewNetnsLister returns a NetnsLister scanning dir and paths.
+func NewNetnsLister(dir string, paths []string) *NetnsLister {
+	return &NetnsLister{Dir: dir, Paths: paths, Workers: DefaultWorkers}
+}
+
+func (l *NetnsLister) NamespacedDevices(context.Context, DeviceFilter) ([]NamespacedDevice, error) {
+	return nil, errors.New("network namespaces are only supported on Linux")
+}
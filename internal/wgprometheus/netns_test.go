@@ -0,0 +1,119 @@
+package wgprometheus
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+type mockNamespaceLister struct {
+	devices []NamespacedDevice
+	err     error
+	filter  DeviceFilter
+}
+
+func (m *mockNamespaceLister) NamespacedDevices(_ context.Context, filter DeviceFilter) ([]NamespacedDevice, error) {
+	m.filter = filter
+	return m.devices, m.err
+}
+
+func TestCollectWithNamespaces(t *testing.T) {
+	peer1 := newTestPeer(1, 100, 200, time.Unix(1000, 0))
+	peer2 := newTestPeer(2, 300, 400, time.Unix(2000, 0))
+
+	lister := &mockNamespaceLister{
+		devices: []NamespacedDevice{
+			{Name: "wg0", Device: &wgtypes.Device{Name: "wg0", Peers: []wgtypes.Peer{peer1}}},
+			{Namespace: "tenant-a", Name: "wg0", Device: &wgtypes.Device{Name: "wg0", Peers: []wgtypes.Peer{peer2}}},
+		},
+	}
+
+	c := NewCollectorWithDevices(nil, &mockDeviceLister{}, WithNamespaceLister(lister))
+	fm := familyMap(collectMetrics(t, c))
+
+	for _, name := range []string{"wireguard_interface_info", "wireguard_interface_peers", "wireguard_peer_up"} {
+		require.Contains(t, fm, name)
+		namespaces := make(map[string]string)
+		for _, metric := range fm[name].GetMetric() {
+			labels := labelMap(metric)
+			assert.Equal(t, "wg0", labels["interface"], name)
+			namespaces[labels["netns"]] = labels["public_key"]
+		}
+		assert.Len(t, namespaces, 2, name)
+		assert.Contains(t, namespaces, "tenant-a", name)
+	}
+
+	peerNamespaces := make(map[string]string)
+	for _, metric := range fm["wireguard_peer_up"].GetMetric() {
+		labels := labelMap(metric)
+		peerNamespaces[labels["public_key"]] = labels["netns"]
+	}
+	assert.Equal(t, "", peerNamespaces[peer1.PublicKey.String()])
+	assert.Equal(t, "tenant-a", peerNamespaces[peer2.PublicKey.String()])
+}
+
+func TestCollectNamespaceListerError(t *testing.T) {
+	lister := &mockNamespaceLister{err: errors.New("permission denied")}
+
+	c := NewCollectorWithDevices(nil, &mockDeviceLister{}, WithNamespaceLister(lister))
+	fm := familyMap(collectMetrics(t, c))
+
+	require.Contains(t, fm, "wireguard_scrape_success")
+	assert.Equal(t, 0.0, fm["wireguard_scrape_success"].GetMetric()[0].GetGauge().GetValue())
+}
+
+func TestCollectNamespaceFilter(t *testing.T) {
+	lister := &mockNamespaceLister{
+		devices: []NamespacedDevice{
+			{Name: "wg0", Device: &wgtypes.Device{Name: "wg0"}},
+			{Name: "wg9", Err: os.ErrNotExist},
+		},
+	}
+	c := NewCollectorWithDevices([]string{"wg0", "wg1", "wg9"}, &mockDeviceLister{},
+		WithNamespaceLister(lister), WithInterfaceExclude(mustPatterns(t, "wg1")))
+	fm := familyMap(collectMetrics(t, c))
+
+	// The lister is told which interfaces to read and which must exist.
+	assert.True(t, lister.filter.Monitor("wg0"))
+	assert.False(t, lister.filter.Monitor("wg1"))
+	assert.False(t, lister.filter.Monitor("wg2"))
+	assert.Contains(t, lister.filter.Required, "wg9")
+
+	errs := fm["wireguard_interface_scrape_errors_total"].GetMetric()
+	require.Len(t, errs, 1)
+	assert.Equal(t, "wg9", labelMap(errs[0])["interface"])
+	assert.Equal(t, "not_found", labelMap(errs[0])["class"])
+}
+
+func TestCollectWithContainerLabels(t *testing.T) {
+	peer := newTestPeer(1, 100, 200, time.Unix(1000, 0))
+
+	lister := &mockNamespaceLister{
+		devices: []NamespacedDevice{
+			{
+				Namespace:     "/proc/4242/ns/net",
+				ContainerID:   "3f4e1c2a9b8d7e6f5a4b3c2d1e0f9a8b7c6d5e4f3a2b1c0d9e8f7a6b5c4d3e2f",
+				ContainerName: "wireguard_exporter_1",
+				Name:          "wg0",
+				Device:        &wgtypes.Device{Name: "wg0", Peers: []wgtypes.Peer{peer}},
+			},
+		},
+	}
+
+	c := NewCollectorWithDevices(nil, &mockDeviceLister{}, WithNamespaceLister(lister), WithContainerLabels())
+	fm := familyMap(collectMetrics(t, c))
+
+	for _, name := range []string{"wireguard_interface_info", "wireguard_interface_peers_up", "wireguard_peer_up"} {
+		require.Contains(t, fm, name)
+		labels := labelMap(fm[name].GetMetric()[0])
+		assert.Equal(t, "wireguard_exporter_1", labels["container"], name)
+		assert.Equal(t, "3f4e1c2a9b8d7e6f5a4b3c2d1e0f9a8b7c6d5e4f3a2b1c0d9e8f7a6b5c4d3e2f", labels["container_id"], name)
+		assert.Equal(t, "/proc/4242/ns/net", labels["netns"], name)
+	}
+}
@@ -0,0 +1,173 @@
+package wgprometheus
+
+import (
+	"fmt"
+	"net"
+	"slices"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/sathiraumesh/wireguard_exporter/internal/peermeta"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// maxPeerMetrics is the most metrics collectPeer emits for a peer, not
+// counting wireguard_peer_allowed_ip_info.
+var maxPeerMetrics = 21 + len(peerStates)
+
+// cachedMetric is a const metric whose label pairs are shared between
+// metrics and scrapes instead of being rebuilt for every metric. The
+// label pairs must not be modified once built.
+type cachedMetric struct {
+	desc   *prometheus.Desc
+	typ    prometheus.ValueType
+	value  float64
+	labels []*dto.LabelPair
+}
+
+func (m *cachedMetric) Desc() *prometheus.Desc {
+	return m.desc
+}
+
+func (m *cachedMetric) Write(out *dto.Metric) error {
+	out.Label = m.labels
+	if m.typ == prometheus.CounterValue {
+		out.Counter = &dto.Counter{Value: &m.value}
+	} else {
+		out.Gauge = &dto.Gauge{Value: &m.value}
+	}
+	return nil
+}
+
+// peerLabelKey identifies a peer across scrapes: the joined interface
+// label values and the peer's public key.
+type peerLabelKey struct {
+	iface  string
+	pubKey wgtypes.Key
+}
+
+// peerLabels holds the label values and label pairs of one peer, together
+// with the inputs they were built from. Entries are immutable apart from
+// seen, which is guarded by Collector.labelMu; a peer whose inputs change
+// gets a new entry.
+type peerLabels struct {
+	key peerLabelKey
+
+	descs      *peerDescs
+	meta       *peermeta.Metadata
+	friendly   string
+	allowedIPs []net.IPNet
+	endpoint   *net.UDPAddr
+
+	pubKey        string
+	pairs         []*dto.LabelPair
+	states        [][]*dto.LabelPair // indexed like peerStates
+	allowedIPInfo [][]*dto.LabelPair // one per allowed IP, info mode only
+	endpointPairs []*dto.LabelPair   // nil without an endpoint
+
+	seen uint64
+}
+
+// matches reports whether e is still valid for peer in scrape s.
+func (e *peerLabels) matches(s *scrape, peer *wgtypes.Peer, friendlyNames map[string]string) bool {
+	return e.descs == s.descs &&
+		e.meta == s.meta &&
+		friendlyNames[e.pubKey] == e.friendly &&
+		allowedIPsEqual(e.allowedIPs, peer.AllowedIPs) &&
+		endpointEqual(e.endpoint, peer.Endpoint)
+}
+
+// peerLabelsFor returns the cached labels of peer, building them when the
+// peer is new or its label inputs changed.
+func (c *Collector) peerLabelsFor(s *scrape, ifaceKey string, ifaceValues []string, peer *wgtypes.Peer, friendlyNames map[string]string) *peerLabels {
+	key := peerLabelKey{iface: ifaceKey, pubKey: peer.PublicKey}
+
+	c.labelMu.Lock()
+	e, ok := c.labelCache[key]
+	if ok {
+		e.seen = s.gen
+	}
+	c.labelMu.Unlock()
+	if ok && e.matches(s, peer, friendlyNames) {
+		return e
+	}
+
+	e = c.newPeerLabels(s, ifaceValues, peer, friendlyNames)
+	e.key = key
+	e.seen = s.gen
+	c.labelMu.Lock()
+	c.labelCache[key] = e
+	c.labelMu.Unlock()
+	return e
+}
+
+func (c *Collector) newPeerLabels(s *scrape, ifaceValues []string, peer *wgtypes.Peer, friendlyNames map[string]string) *peerLabels {
+	pubKey := peer.PublicKey.String()
+	e := &peerLabels{
+		descs:      s.descs,
+		meta:       s.meta,
+		friendly:   friendlyNames[pubKey],
+		allowedIPs: slices.Clone(peer.AllowedIPs),
+		pubKey:     pubKey,
+	}
+	if peer.Endpoint != nil {
+		endpoint := *peer.Endpoint
+		e.endpoint = &endpoint
+	}
+
+	values := append(slices.Clone(ifaceValues), pubKey)
+	if c.allowedIPsMode == AllowedIPsLabel {
+		values = append(values, fmt.Sprintf("%v", peer.AllowedIPs))
+	}
+	if c.wgQuick != nil {
+		values = append(values, e.friendly)
+	}
+	if c.metadata != nil {
+		values = appendMetadataValues(values, s.meta, s.metaKeys, pubKey)
+	}
+
+	descs := s.descs
+	e.pairs = prometheus.MakeLabelPairs(descs.handshake, values)
+	for _, st := range peerStates {
+		e.states = append(e.states, prometheus.MakeLabelPairs(descs.peerState, append(slices.Clone(values), st)))
+	}
+	if c.allowedIPsMode == AllowedIPsInfo {
+		for _, prefix := range peer.AllowedIPs {
+			e.allowedIPInfo = append(e.allowedIPInfo, prometheus.MakeLabelPairs(
+				descs.allowedIPInfo, slices.Concat(values, []string{prefix.String(), ipFamily(prefix.IP)}),
+			))
+		}
+	}
+	if peer.Endpoint != nil {
+		e.endpointPairs = prometheus.MakeLabelPairs(descs.endpoint, slices.Concat(values, endpointLabelValues(peer.Endpoint)))
+	}
+	return e
+}
+
+// sweepPeerLabels drops the entries of peers not seen since the scrape
+// before gen, so removed peers do not accumulate.
+func (c *Collector) sweepPeerLabels(gen uint64) {
+	c.labelMu.Lock()
+	defer c.labelMu.Unlock()
+
+	for key, e := range c.labelCache {
+		if e.seen+1 < gen {
+			delete(c.labelCache, key)
+		}
+	}
+}
+
+// allowedIPsEqual compares prefixes byte for byte, since the allowed_ips
+// label renders IPv4 and IPv4-mapped IPv6 addresses differently.
+func allowedIPsEqual(a, b []net.IPNet) bool {
+	return slices.EqualFunc(a, b, func(x, y net.IPNet) bool {
+		return slices.Equal(x.IP, y.IP) && slices.Equal(x.Mask, y.Mask)
+	})
+}
+
+func endpointEqual(a, b *net.UDPAddr) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Port == b.Port && a.Zone == b.Zone && a.IP.Equal(b.IP)
+}
@@ -0,0 +1,65 @@
+package wgprometheus
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func TestPeerLabelsFollowPeerChanges(t *testing.T) {
+	peer := newTestPeer(1, 100, 200, time.Unix(1000, 0))
+	peer.Endpoint = &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 51820}
+	dev := &wgtypes.Device{Name: "wg0", Peers: []wgtypes.Peer{peer}}
+	c := NewCollectorWithDevices(nil, &mockDeviceLister{devices: []*wgtypes.Device{dev}})
+
+	fm := familyMap(collectMetrics(t, c))
+	assert.Equal(t, "[{10.0.0.1 ffffffff}]", labelMap(fm["wireguard_peer_up"].GetMetric()[0])["allowed_ips"])
+	assert.Equal(t, "192.0.2.1", labelMap(fm["wireguard_peer_endpoint_info"].GetMetric()[0])["endpoint_ip"])
+
+	// Roaming and a route change are picked up on the next scrape.
+	dev.Peers[0].Endpoint = &net.UDPAddr{IP: net.IPv4(198, 51, 100, 7), Port: 51820}
+	dev.Peers[0].AllowedIPs = []net.IPNet{{IP: net.IPv4(10, 0, 0, 2), Mask: net.CIDRMask(32, 32)}}
+	fm = familyMap(collectMetrics(t, c))
+	assert.Equal(t, "[{10.0.0.2 ffffffff}]", labelMap(fm["wireguard_peer_up"].GetMetric()[0])["allowed_ips"])
+	assert.Equal(t, "198.51.100.7", labelMap(fm["wireguard_peer_endpoint_info"].GetMetric()[0])["endpoint_ip"])
+
+	// Values change without rebuilding labels.
+	dev.Peers[0].TransmitBytes = 999
+	fm = familyMap(collectMetrics(t, c))
+	assert.Equal(t, 999.0, fm["wireguard_peer_transmit_bytes_total"].GetMetric()[0].GetCounter().GetValue())
+
+	// The endpoint info disappears with the endpoint.
+	dev.Peers[0].Endpoint = nil
+	fm = familyMap(collectMetrics(t, c))
+	assert.NotContains(t, fm, "wireguard_peer_endpoint_info")
+}
+
+func TestPeerLabelCacheDropsRemovedPeers(t *testing.T) {
+	dev := &wgtypes.Device{Name: "wg0", Peers: []wgtypes.Peer{
+		newTestPeer(1, 0, 0, time.Time{}),
+		newTestPeer(2, 0, 0, time.Time{}),
+	}}
+	c := NewCollectorWithDevices(nil, &mockDeviceLister{devices: []*wgtypes.Device{dev}})
+
+	collectMetrics(t, c)
+	require.Len(t, c.labelCache, 2)
+
+	dev.Peers = dev.Peers[:1]
+	collectMetrics(t, c)
+	collectMetrics(t, c)
+	assert.Len(t, c.labelCache, 1)
+}
+
+func TestAllowedIPsEqual(t *testing.T) {
+	v4 := net.IPNet{IP: net.IPv4(10, 0, 0, 1).To4(), Mask: net.CIDRMask(32, 32)}
+	mapped := net.IPNet{IP: net.IPv4(10, 0, 0, 1), Mask: net.CIDRMask(32, 32)}
+
+	assert.True(t, allowedIPsEqual([]net.IPNet{v4}, []net.IPNet{v4}))
+	assert.True(t, allowedIPsEqual(nil, []net.IPNet{}))
+	assert.False(t, allowedIPsEqual([]net.IPNet{v4}, []net.IPNet{mapped}))
+	assert.False(t, allowedIPsEqual([]net.IPNet{v4}, nil))
+}
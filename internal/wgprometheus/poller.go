@@ -0,0 +1,168 @@
+package wgprometheus
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/sync/singleflight"
+)
+
+var snapshotAgeDesc = prometheus.NewDesc(
+	"wireguard_snapshot_age_seconds",
+	"Age of the device snapshot served by the background poller in seconds.",
+	nil, nil,
+)
+
+// snapshot is the result of one device listing. timedOut is set when the
+// listing hit its deadline, in which case devices may be partial. rates
+// holds the peer throughput since the previous snapshot, totals the
+// cumulative counters and sessions the peer session history when enabled.
+type snapshot struct {
+	devices  []NamespacedDevice
+	err      error
+	timedOut bool
+	taken    time.Time
+	rates    map[peerLabelKey]peerRate
+	totals   map[peerLabelKey]byteTotals
+	sessions map[peerLabelKey]peerSession
+}
+
+// poller keeps the latest snapshot taken in the background.
+type poller struct {
+	mu      sync.RWMutex
+	current snapshot
+}
+
+func (p *poller) get() snapshot {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.current
+}
+
+func (p *poller) set(s snapshot) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.current = s
+}
+
+// StartPolling makes scrapes serve a device snapshot refreshed every
+// interval instead of querying WireGuard on each scrape. The first snapshot
+// is taken before StartPolling returns; polling stops when ctx is done.
+// Each poll is given at most one interval, capped at the scrape timeout.
+// It must be called before the collector is registered.
+func (c *Collector) StartPolling(ctx context.Context, interval time.Duration) {
+	p := &poller{}
+	refresh := func() {
+		pollCtx, cancel := context.WithTimeout(ctx, interval)
+		defer cancel()
+		snap := c.fetchDevices(pollCtx)
+		if ctx.Err() != nil {
+			return
+		}
+		if snap.err != nil {
+			slog.Error("failed to poll WireGuard devices", "error", snap.err)
+		}
+		p.set(snap)
+	}
+	refresh()
+	c.poller = p
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				refresh()
+			}
+		}
+	}()
+}
+
+// fetchDevices lists devices, sharing one in-flight listing between
+// concurrent callers so parallel scrapes do not multiply netlink queries.
+// A caller whose ctx is done stops waiting and gets an empty snapshot; the
+// listing goes on for the others. Only the caller that started the
+// listing keeps waiting past its deadline: the listing stops at that
+// deadline or an earlier one and exports what it read by then.
+func (c *Collector) fetchDevices(ctx context.Context) snapshot {
+	results, started := c.joinFetch(ctx)
+	select {
+	case res := <-results:
+		return res.Val.(snapshot)
+	case <-ctx.Done():
+	}
+	timedOut := errors.Is(ctx.Err(), context.DeadlineExceeded)
+	if timedOut && started.Load() {
+		res := <-results
+		return res.Val.(snapshot)
+	}
+	return snapshot{err: ctx.Err(), timedOut: timedOut, taken: time.Now()}
+}
+
+// joinFetch starts the shared listing, or joins the one in flight. It
+// returns the channel the snapshot is delivered on, and whether this call
+// started the listing, which is set once the listing runs.
+func (c *Collector) joinFetch(ctx context.Context) (<-chan singleflight.Result, *atomic.Bool) {
+	started := new(atomic.Bool)
+	results := c.inflight.DoChan("devices", func() (any, error) {
+		started.Store(true)
+		readCtx, cancel := c.readContext(ctx)
+		defer cancel()
+		return c.takeSnapshot(readCtx), nil
+	})
+	return results, started
+}
+
+// readContext returns the context of a listing started by a caller with
+// ctx. It keeps the deadline of ctx, capped at the scrape timeout, but not
+// its cancellation, since other callers may join the listing.
+func (c *Collector) readContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	deadline, ok := ctx.Deadline()
+	if c.scrapeTimeout > 0 {
+		if limit := time.Now().Add(c.scrapeTimeout); !ok || limit.Before(deadline) {
+			deadline, ok = limit, true
+		}
+	}
+	detached := context.WithoutCancel(ctx)
+	if !ok {
+		return context.WithCancel(detached)
+	}
+	return context.WithDeadline(detached, deadline)
+}
+
+// takeSnapshot lists devices and updates the per-peer state derived from
+// consecutive reads.
+func (c *Collector) takeSnapshot(ctx context.Context) snapshot {
+	devices, err := c.listDevices(ctx)
+	devices = c.dropUnknownUnread(devices)
+	c.recordErrors(devices)
+	snap := snapshot{
+		devices:  devices,
+		err:      err,
+		timedOut: errors.Is(ctx.Err(), context.DeadlineExceeded),
+		taken:    time.Now(),
+	}
+	if err == nil && !snap.timedOut {
+		c.forgetInterfaces(devices)
+	}
+	if err == nil {
+		samples := c.samplePeers(devices, snap.taken)
+		if c.counters != nil {
+			snap.totals, _ = c.counters.update(samples.peers, samples.ifaces)
+		}
+		c.recordTraffic(snap.taken, samples)
+		if c.sessions != nil {
+			snap.sessions = c.sessions.update(samples.links, samples.failed, snap.taken)
+		}
+		snap.rates = c.rates.update(samples.peers, samples.failed)
+	}
+	return snap
+}
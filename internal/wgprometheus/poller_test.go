@@ -0,0 +1,160 @@
+package wgprometheus
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sync/singleflight"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// countingDeviceLister counts calls and optionally blocks until released.
+type countingDeviceLister struct {
+	calls   atomic.Int32
+	release chan struct{}
+	devices []*wgtypes.Device
+	err     error
+}
+
+func (l *countingDeviceLister) Devices() ([]*wgtypes.Device, error) {
+	l.calls.Add(1)
+	if l.release != nil {
+		<-l.release
+	}
+	return l.devices, l.err
+}
+
+func TestConcurrentScrapesShareOneListing(t *testing.T) {
+	lister := &countingDeviceLister{
+		release: make(chan struct{}),
+		devices: []*wgtypes.Device{{Name: "wg0"}},
+	}
+	c := NewCollectorWithDevices(nil, lister)
+
+	// Every scrape joins the listing before it is released, so none can
+	// start a listing of its own.
+	const scrapes = 5
+	results := make([]<-chan singleflight.Result, scrapes)
+	for i := range results {
+		results[i], _ = c.joinFetch(context.Background())
+	}
+	close(lister.release)
+
+	for _, ch := range results {
+		res := <-ch
+		assert.True(t, res.Shared)
+		snap := res.Val.(snapshot)
+		assert.NoError(t, snap.err)
+		assert.Len(t, snap.devices, 1)
+	}
+	assert.Equal(t, int32(1), lister.calls.Load())
+}
+
+func TestCanceledScrapeKeepsSharedListing(t *testing.T) {
+	lister := &countingDeviceLister{
+		release: make(chan struct{}),
+		devices: []*wgtypes.Device{{Name: "wg0"}},
+	}
+	c := NewCollectorWithDevices(nil, lister)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan snapshot, 1)
+	go func() { first <- c.fetchDevices(ctx) }()
+	require.Eventually(t, func() bool { return lister.calls.Load() == 1 }, time.Second, time.Millisecond)
+	second, _ := c.joinFetch(context.Background())
+
+	// The client that started the listing goes away.
+	cancel()
+	assert.ErrorIs(t, (<-first).err, context.Canceled)
+
+	close(lister.release)
+	res := <-second
+	assert.True(t, res.Shared)
+	snap := res.Val.(snapshot)
+	assert.NoError(t, snap.err)
+	assert.False(t, snap.timedOut)
+	assert.Len(t, snap.devices, 1)
+}
+
+func TestJoinedScrapeKeepsItsDeadline(t *testing.T) {
+	lister := &countingDeviceLister{
+		release: make(chan struct{}),
+		devices: []*wgtypes.Device{{Name: "wg0"}},
+	}
+	c := NewCollectorWithDevices(nil, lister)
+
+	first, _ := c.joinFetch(context.Background())
+	require.Eventually(t, func() bool { return lister.calls.Load() == 1 }, time.Second, time.Millisecond)
+
+	// A scrape with a shorter deadline joins and gives up at its deadline.
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	snap := c.fetchDevices(ctx)
+	assert.Less(t, time.Since(start), time.Second)
+	assert.True(t, snap.timedOut)
+	assert.ErrorIs(t, snap.err, context.DeadlineExceeded)
+
+	close(lister.release)
+	res := <-first
+	assert.NoError(t, res.Val.(snapshot).err)
+	assert.Len(t, res.Val.(snapshot).devices, 1)
+}
+
+func TestScrapeTimeoutBoundsListing(t *testing.T) {
+	lister := &countingDeviceLister{
+		release: make(chan struct{}),
+		devices: []*wgtypes.Device{{Name: "wg0"}},
+	}
+	t.Cleanup(func() { close(lister.release) })
+	c := NewCollectorWithDevices(nil, lister, WithScrapeTimeout(20*time.Millisecond))
+
+	fm := familyMap(collectMetrics(t, c))
+	assert.Equal(t, 0.0, fm["wireguard_scrape_success"].GetMetric()[0].GetGauge().GetValue())
+	assert.Equal(t, 1.0, fm["wireguard_scrape_timed_out"].GetMetric()[0].GetGauge().GetValue())
+}
+
+func TestStartPollingServesSnapshot(t *testing.T) {
+	lister := &countingDeviceLister{
+		devices: []*wgtypes.Device{
+			{Name: "wg0", Peers: []wgtypes.Peer{newTestPeer(1, 100, 200, time.Unix(1000, 0))}},
+		},
+	}
+	c := NewCollectorWithDevices(nil, lister)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c.StartPolling(ctx, time.Hour)
+	require.Equal(t, int32(1), lister.calls.Load())
+
+	for range 3 {
+		fm := familyMap(collectMetrics(t, c))
+		require.Contains(t, fm, "wireguard_peer_up")
+		require.Contains(t, fm, "wireguard_snapshot_age_seconds")
+		assert.GreaterOrEqual(t, fm["wireguard_snapshot_age_seconds"].GetMetric()[0].GetGauge().GetValue(), 0.0)
+		assert.Equal(t, 1.0, fm["wireguard_scrape_success"].GetMetric()[0].GetGauge().GetValue())
+	}
+
+	// Scrapes are served from the snapshot without listing devices again.
+	assert.Equal(t, int32(1), lister.calls.Load())
+}
+
+func TestStartPollingRefreshes(t *testing.T) {
+	lister := &countingDeviceLister{err: errors.New("netlink: permission denied")}
+	c := NewCollectorWithDevices(nil, lister)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c.StartPolling(ctx, 5*time.Millisecond)
+
+	fm := familyMap(collectMetrics(t, c))
+	assert.Equal(t, 0.0, fm["wireguard_scrape_success"].GetMetric()[0].GetGauge().GetValue())
+	assert.Contains(t, fm, "wireguard_snapshot_age_seconds")
+
+	require.Eventually(t, func() bool { return lister.calls.Load() >= 3 }, time.Second, time.Millisecond)
+}
@@ -0,0 +1,53 @@
+package wgprometheus
+
+import (
+	"log/slog"
+
+	"github.com/sathiraumesh/wireguard_exporter/internal/accounting"
+	"github.com/sathiraumesh/wireguard_exporter/internal/quota"
+)
+
+// WithQuotas exports the traffic quota of the peers with a quota in src,
+// and the share of it used in the current billing window. Usage is read
+// from the traffic accounting, so WithAccounting must also be set.
+func WithQuotas(src *quota.Source) Option {
+	return func(c *Collector) {
+		c.quotas = src
+	}
+}
+
+// loadQuotas reads the quotas and the traffic of the current billing
+// window for scrape s. The store keeps that window in memory, so only the
+// first scrape of a window reads the buckets from disk. On failure the
+// scrape has no quota metrics.
+func (c *Collector) loadQuotas(s *scrape) {
+	if c.quotas == nil || c.accounting == nil {
+		return
+	}
+	cfg := c.quotas.Config()
+	g := accounting.Monthly
+	if cfg.Window() == quota.Daily {
+		g = accounting.Daily
+	}
+	usage, err := c.accounting.Usage(g, s.now)
+	if err != nil {
+		slog.Warn("failed to read traffic accounting for quotas", "error", err)
+		return
+	}
+	s.quotas, s.quotaUsage = cfg, usage
+}
+
+// peerQuota returns the quota of the peer of d with the given public key
+// and the bytes it transmitted and received in the billing window.
+func (s *scrape) peerQuota(d *deviceScrape, pubKey string) (limit, used int64, ok bool) {
+	if s.quotas == nil {
+		return 0, 0, false
+	}
+	meta, _ := s.meta.Lookup(pubKey)
+	size, ok := s.quotas.Lookup(pubKey, meta.Labels)
+	if !ok {
+		return 0, 0, false
+	}
+	usage := s.quotaUsage[accounting.Peer{Namespace: d.namespace, Interface: d.dev.Name, PublicKey: pubKey}]
+	return int64(size), usage.Total(), true
+}
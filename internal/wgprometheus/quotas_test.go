@@ -0,0 +1,65 @@
+package wgprometheus
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sathiraumesh/wireguard_exporter/internal/accounting"
+	"github.com/sathiraumesh/wireguard_exporter/internal/peermeta"
+	"github.com/sathiraumesh/wireguard_exporter/internal/quota"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func TestCollectQuotas(t *testing.T) {
+	dir := t.TempDir()
+	alice := newTestPeer(1, 0, 0, time.Unix(1000, 0))
+	bob := newTestPeer(2, 0, 0, time.Unix(1000, 0))
+	carol := newTestPeer(3, 0, 0, time.Unix(1000, 0))
+	lister := &mockDeviceLister{devices: []*wgtypes.Device{{Name: "wg0", Peers: []wgtypes.Peer{alice, bob, carol}}}}
+
+	metaPath := filepath.Join(dir, "peers.yaml")
+	require.NoError(t, os.WriteFile(metaPath, []byte("peers:\n  "+bob.PublicKey.String()+":\n    name: bob\n    labels:\n      team: contractors\n"), 0o600))
+	meta, err := peermeta.NewSource(metaPath)
+	require.NoError(t, err)
+
+	quotaPath := filepath.Join(dir, "quotas.yaml")
+	require.NoError(t, os.WriteFile(quotaPath, []byte(`quotas:
+  - public_key: `+alice.PublicKey.String()+`
+    bytes: 1000
+  - labels: {team: contractors}
+    bytes: 400
+`), 0o600))
+	quotas, err := quota.NewSource(quotaPath)
+	require.NoError(t, err)
+
+	store, err := accounting.Open(filepath.Join(dir, "accounting"))
+	require.NoError(t, err)
+	require.NoError(t, store.Record(time.Now(), map[accounting.Peer]accounting.Usage{
+		{Interface: "wg0", PublicKey: alice.PublicKey.String()}: {Transmit: 200, Receive: 50},
+		{Interface: "wg0", PublicKey: bob.PublicKey.String()}:   {Transmit: 500},
+	}))
+	state, err := LoadCounterState(filepath.Join(dir, "counters.json"))
+	require.NoError(t, err)
+
+	c := NewCollectorWithDevices(nil, lister,
+		WithPeerMetadata(meta), WithAccounting(store, state), WithQuotas(quotas))
+	fm := familyMap(collectMetrics(t, c))
+
+	limits := fm["wireguard_peer_quota_bytes"].GetMetric()
+	ratios := fm["wireguard_peer_quota_used_ratio"].GetMetric()
+	require.Len(t, limits, 2)
+	require.Len(t, ratios, 2)
+
+	assert.Equal(t, 1000.0, metricByLabel(limits, "public_key", alice.PublicKey.String()).GetGauge().GetValue())
+	assert.Equal(t, 0.25, metricByLabel(ratios, "public_key", alice.PublicKey.String()).GetGauge().GetValue())
+
+	// bob's quota comes from his metadata group and is exceeded.
+	assert.Equal(t, 400.0, metricByLabel(limits, "public_key", bob.PublicKey.String()).GetGauge().GetValue())
+	assert.Equal(t, 1.25, metricByLabel(ratios, "public_key", bob.PublicKey.String()).GetGauge().GetValue())
+
+	assert.Nil(t, metricByLabel(limits, "public_key", carol.PublicKey.String()))
+}
@@ -0,0 +1,133 @@
+package wgprometheus
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// byteSample is a peer's byte counters as read at a point in time.
+type byteSample struct {
+	transmit, receive int64
+	at                time.Time
+}
+
+// peerRate is a peer's throughput in bytes per second between two reads.
+type peerRate struct {
+	transmit, receive float64
+}
+
+// rateTracker keeps the previous byte sample of every peer so throughput
+// can be computed between consecutive device reads.
+type rateTracker struct {
+	mu   sync.Mutex
+	prev map[peerLabelKey]byteSample
+}
+
+// update records the samples read at the given time and returns the rates
+// of the peers that also have a previous sample. Peers that are missing
+// from samples are forgotten, unless their interface is listed in failed,
+// in which case the next successful read spans the gap. A counter that
+// went backwards was reset, so its current value is taken as the bytes
+// moved since the previous read.
+func (t *rateTracker) update(samples map[peerLabelKey]byteSample, failed map[string]struct{}) map[peerLabelKey]peerRate {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	rates := make(map[peerLabelKey]peerRate, len(samples))
+	for key, cur := range samples {
+		prev, ok := t.prev[key]
+		if !ok {
+			continue
+		}
+		dt := cur.at.Sub(prev.at).Seconds()
+		if dt <= 0 {
+			continue
+		}
+		rates[key] = peerRate{
+			transmit: float64(counterDelta(prev.transmit, cur.transmit)) / dt,
+			receive:  float64(counterDelta(prev.receive, cur.receive)) / dt,
+		}
+	}
+	for key, prev := range t.prev {
+		if _, ok := failed[key.iface]; ok {
+			if _, seen := samples[key]; !seen {
+				samples[key] = prev
+			}
+		}
+	}
+	t.prev = samples
+	return rates
+}
+
+func counterDelta(prev, cur int64) int64 {
+	if cur < prev {
+		return cur
+	}
+	return cur - prev
+}
+
+// peerSamples are the byte samples of one device read, and the handshakes
+// when session tracking is enabled.
+type peerSamples struct {
+	peers map[peerLabelKey]byteSample
+	links map[peerLabelKey]peerLink
+	// failed holds the joined label values of the interfaces whose read
+	// failed, ifaces the interface of every joined key.
+	failed map[string]struct{}
+	ifaces map[string]sampledInterface
+}
+
+// sampledInterface is an interface of a device read. stateValues
+// identify it in counter state.
+type sampledInterface struct {
+	values          []string
+	stateValues     []string
+	namespace, name string
+}
+
+// samplePeers samples the peers of the monitored devices read at the given
+// time that pass the peer filters, so that filtered peers are not tracked,
+// saved or billed.
+func (c *Collector) samplePeers(devices []NamespacedDevice, at time.Time) peerSamples {
+	s := peerSamples{
+		peers:  make(map[peerLabelKey]byteSample),
+		failed: make(map[string]struct{}),
+		ifaces: make(map[string]sampledInterface),
+	}
+	if c.sessions != nil {
+		s.links = make(map[peerLabelKey]peerLink)
+	}
+	for _, nd := range devices {
+		if !c.shouldMonitor(nd.Name) {
+			continue
+		}
+		values := c.interfaceValues(nd)
+		iface := strings.Join(values, "\xff")
+		s.ifaces[iface] = sampledInterface{values: values, stateValues: c.stateValues(nd), namespace: nd.Namespace, name: nd.Name}
+		if nd.Err != nil {
+			s.failed[iface] = struct{}{}
+			continue
+		}
+		for i := range nd.Device.Peers {
+			peer := &nd.Device.Peers[i]
+			if !c.shouldExportPeer(peer) {
+				continue
+			}
+			key := peerLabelKey{iface: iface, pubKey: peer.PublicKey}
+			s.peers[key] = byteSample{
+				transmit: peer.TransmitBytes,
+				receive:  peer.ReceiveBytes,
+				at:       at,
+			}
+			if s.links != nil {
+				link := peerLink{handshake: peer.LastHandshakeTime}
+				if !peer.LastHandshakeTime.IsZero() {
+					link.upUntil = peer.LastHandshakeTime.Add(c.handshakeTimeout(nd.Name, peer))
+				}
+				s.links[key] = link
+			}
+		}
+	}
+	return s
+}
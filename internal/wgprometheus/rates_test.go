@@ -0,0 +1,92 @@
+package wgprometheus
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func TestRateTracker(t *testing.T) {
+	t0 := time.Unix(1000, 0)
+	a := peerLabelKey{iface: "wg0", pubKey: wgtypes.Key{1}}
+	b := peerLabelKey{iface: "wg0", pubKey: wgtypes.Key{2}}
+	c := peerLabelKey{iface: "wg1", pubKey: wgtypes.Key{3}}
+	sample := func(tx, rx int64, at time.Time) byteSample {
+		return byteSample{transmit: tx, receive: rx, at: at}
+	}
+
+	var tr rateTracker
+
+	// The first read has nothing to compare against.
+	rates := tr.update(map[peerLabelKey]byteSample{
+		a: sample(1000, 2000, t0),
+		b: sample(500, 500, t0),
+		c: sample(0, 0, t0),
+	}, nil)
+	assert.Empty(t, rates)
+
+	// b was reset to zero and counted up again; c's interface failed.
+	t1 := t0.Add(10 * time.Second)
+	rates = tr.update(map[peerLabelKey]byteSample{
+		a: sample(3000, 2500, t1),
+		b: sample(100, 50, t1),
+	}, map[string]struct{}{"wg1": {}})
+	assert.Equal(t, map[peerLabelKey]peerRate{
+		a: {transmit: 200, receive: 50},
+		b: {transmit: 10, receive: 5},
+	}, rates)
+
+	// c's rate spans the failed read; b disappeared.
+	t2 := t1.Add(10 * time.Second)
+	rates = tr.update(map[peerLabelKey]byteSample{
+		a: sample(3000, 2500, t2),
+		c: sample(4000, 2000, t2),
+	}, nil)
+	assert.Equal(t, map[peerLabelKey]peerRate{
+		a: {transmit: 0, receive: 0},
+		c: {transmit: 200, receive: 100},
+	}, rates)
+
+	// b returning is treated as a new peer, not as a reset.
+	t3 := t2.Add(10 * time.Second)
+	rates = tr.update(map[peerLabelKey]byteSample{
+		b: sample(10, 10, t3),
+	}, nil)
+	assert.Empty(t, rates)
+}
+
+func TestCollectPeerRates(t *testing.T) {
+	dev := &wgtypes.Device{Name: "wg0", Peers: []wgtypes.Peer{newTestPeer(1, 100, 200, time.Unix(1000, 0))}}
+	c := NewCollectorWithDevices(nil, &mockDeviceLister{devices: []*wgtypes.Device{dev}})
+
+	fm := familyMap(collectMetrics(t, c))
+	assert.NotContains(t, fm, "wireguard_peer_transmit_bytes_per_second")
+	assert.NotContains(t, fm, "wireguard_peer_receive_bytes_per_second")
+
+	dev.Peers[0].TransmitBytes = 1100
+	fm = familyMap(collectMetrics(t, c))
+	require.Contains(t, fm, "wireguard_peer_transmit_bytes_per_second")
+	require.Contains(t, fm, "wireguard_peer_receive_bytes_per_second")
+	assert.Greater(t, fm["wireguard_peer_transmit_bytes_per_second"].GetMetric()[0].GetGauge().GetValue(), 0.0)
+	assert.Equal(t, 0.0, fm["wireguard_peer_receive_bytes_per_second"].GetMetric()[0].GetGauge().GetValue())
+}
+
+func TestPolledPeerRatesStable(t *testing.T) {
+	dev := &wgtypes.Device{Name: "wg0", Peers: []wgtypes.Peer{newTestPeer(1, 100, 200, time.Unix(1000, 0))}}
+	c := NewCollectorWithDevices(nil, &mockDeviceLister{devices: []*wgtypes.Device{dev}})
+
+	// Scrapes between polls serve the rate of the latest poll.
+	snap := c.fetchDevices(t.Context())
+	require.Empty(t, snap.rates)
+	dev.Peers[0].TransmitBytes = 1100
+	c.poller = &poller{}
+	c.poller.set(c.fetchDevices(t.Context()))
+
+	first := familyMap(collectMetrics(t, c))["wireguard_peer_transmit_bytes_per_second"].GetMetric()[0].GetGauge().GetValue()
+	second := familyMap(collectMetrics(t, c))["wireguard_peer_transmit_bytes_per_second"].GetMetric()[0].GetGauge().GetValue()
+	assert.Greater(t, first, 0.0)
+	assert.Equal(t, first, second)
+}
@@ -0,0 +1,130 @@
+package wgprometheus
+
+import (
+	"sync"
+	"time"
+)
+
+// WithPeerSessions exports the session history of every peer: when the
+// exporter first saw it, when its current session began, how many
+// sessions it had and how long it has been connected. A session is a
+// continuous period in which the peer is up, as reported by
+// wireguard_peer_up.
+func WithPeerSessions() Option {
+	return func(c *Collector) {
+		c.sessions = &sessionTracker{}
+	}
+}
+
+// peerLink is a peer's handshake as read at a point in time. The peer is
+// up until upUntil, which is zero for peers that never handshaked.
+type peerLink struct {
+	handshake time.Time
+	upUntil   time.Time
+}
+
+// peerSession is the session history of a peer as of a device read.
+// start is zero while the peer is down.
+type peerSession struct {
+	firstSeen time.Time
+	start     time.Time
+	count     int
+	connected time.Duration
+}
+
+// sessionState is the session history of a peer between device reads.
+// While a session is active, until is when it expires unless the peer
+// handshakes again.
+type sessionState struct {
+	firstSeen time.Time
+	start     time.Time
+	until     time.Time
+	count     int
+	closed    time.Duration
+}
+
+// observe advances the session history to a device read taken at the
+// given time.
+func (s *sessionState) observe(link peerLink, at time.Time) {
+	up := at.Before(link.upUntil)
+	if !s.start.IsZero() {
+		switch {
+		case link.handshake.After(s.until):
+			// The session expired and the peer came back between two
+			// reads, so the handshake starts a new session.
+			s.end(s.until)
+		case !up:
+			// The handshake is lost when the interface is recreated, so
+			// the session lasted at least until its last known expiry.
+			end := s.until
+			if link.upUntil.After(end) {
+				end = link.upUntil
+			}
+			if at.Before(end) {
+				end = at
+			}
+			s.end(end)
+		default:
+			s.until = link.upUntil
+			return
+		}
+	}
+	if up {
+		s.start = link.handshake
+		s.until = link.upUntil
+		s.count++
+	}
+}
+
+func (s *sessionState) end(at time.Time) {
+	s.closed += max(at.Sub(s.start), 0)
+	s.start = time.Time{}
+	s.until = time.Time{}
+}
+
+func (s *sessionState) session(at time.Time) peerSession {
+	p := peerSession{firstSeen: s.firstSeen, start: s.start, count: s.count, connected: s.closed}
+	if !s.start.IsZero() {
+		p.connected += max(at.Sub(s.start), 0)
+	}
+	return p
+}
+
+// sessionTracker follows the sessions of every peer across device reads.
+// Sessions that were already active when the exporter started begin at
+// the peer's latest handshake.
+type sessionTracker struct {
+	mu    sync.Mutex
+	peers map[peerLabelKey]*sessionState
+}
+
+// update records the links read at the given time and returns the session
+// history of every peer read. Peers that are missing from links are
+// forgotten, unless their interface is listed in failed.
+func (t *sessionTracker) update(links map[peerLabelKey]peerLink, failed map[string]struct{}, at time.Time) map[peerLabelKey]peerSession {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.peers == nil {
+		t.peers = make(map[peerLabelKey]*sessionState, len(links))
+	}
+	sessions := make(map[peerLabelKey]peerSession, len(links))
+	for key, link := range links {
+		st, ok := t.peers[key]
+		if !ok {
+			st = &sessionState{firstSeen: at}
+			t.peers[key] = st
+		}
+		st.observe(link, at)
+		sessions[key] = st.session(at)
+	}
+	for key := range t.peers {
+		if _, ok := links[key]; ok {
+			continue
+		}
+		if _, ok := failed[key.iface]; !ok {
+			delete(t.peers, key)
+		}
+	}
+	return sessions
+}
@@ -0,0 +1,89 @@
+package wgprometheus
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func TestSessionTracker(t *testing.T) {
+	t0 := time.Unix(10000, 0)
+	a := peerLabelKey{iface: "wg0", pubKey: wgtypes.Key{1}}
+	b := peerLabelKey{iface: "wg0", pubKey: wgtypes.Key{2}}
+	link := func(handshake time.Time) peerLink {
+		return peerLink{handshake: handshake, upUntil: handshake.Add(5 * time.Minute)}
+	}
+	at := func(seconds int) time.Time {
+		return t0.Add(time.Duration(seconds) * time.Second)
+	}
+	var tr sessionTracker
+
+	// A session in progress at startup begins at the latest handshake.
+	sessions := tr.update(map[peerLabelKey]peerLink{a: link(at(-60)), b: {}}, nil, at(0))
+	assert.Equal(t, peerSession{firstSeen: at(0), start: at(-60), count: 1, connected: time.Minute}, sessions[a])
+	assert.Equal(t, peerSession{firstSeen: at(0)}, sessions[b])
+
+	// Rekeying keeps the session going.
+	sessions = tr.update(map[peerLabelKey]peerLink{a: link(at(30)), b: {}}, nil, at(60))
+	assert.Equal(t, peerSession{firstSeen: at(0), start: at(-60), count: 1, connected: 2 * time.Minute}, sessions[a])
+
+	// The session ends when the handshake expires, not at the read.
+	sessions = tr.update(map[peerLabelKey]peerLink{a: link(at(30)), b: {}}, nil, at(600))
+	assert.Equal(t, peerSession{firstSeen: at(0), count: 1, connected: 390 * time.Second}, sessions[a])
+
+	sessions = tr.update(map[peerLabelKey]peerLink{a: link(at(840)), b: {}}, nil, at(900))
+	assert.Equal(t, peerSession{firstSeen: at(0), start: at(840), count: 2, connected: 450 * time.Second}, sessions[a])
+
+	// A handshake after the expiry of the session starts a new one, even
+	// though both reads saw the peer up.
+	sessions = tr.update(map[peerLabelKey]peerLink{a: link(at(1900)), b: {}}, nil, at(2000))
+	assert.Equal(t, peerSession{firstSeen: at(0), start: at(1900), count: 3, connected: 790 * time.Second}, sessions[a])
+
+	// A failed read keeps the history of the interface's peers.
+	sessions = tr.update(nil, map[string]struct{}{"wg0": {}}, at(2060))
+	assert.Empty(t, sessions)
+	sessions = tr.update(map[peerLabelKey]peerLink{a: link(at(2040))}, nil, at(2120))
+	assert.Equal(t, peerSession{firstSeen: at(0), start: at(1900), count: 3, connected: 910 * time.Second}, sessions[a])
+
+	// Removed peers are forgotten.
+	sessions = tr.update(map[peerLabelKey]peerLink{a: link(at(2040)), b: {}}, nil, at(2180))
+	assert.Equal(t, peerSession{firstSeen: at(2180)}, sessions[b])
+}
+
+func TestSessionTrackerLostHandshake(t *testing.T) {
+	key := peerLabelKey{iface: "wg0", pubKey: wgtypes.Key{1}}
+	t0 := time.Unix(10000, 0)
+	var tr sessionTracker
+
+	tr.update(map[peerLabelKey]peerLink{key: {handshake: t0, upUntil: t0.Add(5 * time.Minute)}}, nil, t0.Add(time.Minute))
+	// The interface was recreated and the peer has not handshaked since.
+	sessions := tr.update(map[peerLabelKey]peerLink{key: {}}, nil, t0.Add(2*time.Minute))
+	assert.Equal(t, peerSession{firstSeen: t0.Add(time.Minute), count: 1, connected: 2 * time.Minute}, sessions[key])
+}
+
+func TestCollectPeerSessions(t *testing.T) {
+	up := newTestPeer(1, 0, 0, time.Now().Add(-time.Minute))
+	never := newTestPeer(2, 0, 0, time.Time{})
+	lister := &mockDeviceLister{devices: []*wgtypes.Device{{Name: "wg0", Peers: []wgtypes.Peer{up, never}}}}
+
+	fm := familyMap(collectMetrics(t, NewCollectorWithDevices(nil, lister)))
+	assert.NotContains(t, fm, "wireguard_peer_first_seen_timestamp_seconds")
+	assert.NotContains(t, fm, "wireguard_peer_sessions_total")
+
+	fm = familyMap(collectMetrics(t, NewCollectorWithDevices(nil, lister, WithPeerSessions())))
+	require.Len(t, fm["wireguard_peer_first_seen_timestamp_seconds"].GetMetric(), 2)
+	starts := fm["wireguard_peer_session_start_timestamp_seconds"].GetMetric()
+	require.Len(t, starts, 1)
+	assert.Equal(t, float64(up.LastHandshakeTime.Unix()), starts[0].GetGauge().GetValue())
+
+	sessions := fm["wireguard_peer_sessions_total"].GetMetric()
+	assert.Equal(t, 1.0, metricByLabel(sessions, "public_key", up.PublicKey.String()).GetCounter().GetValue())
+	assert.Equal(t, 0.0, metricByLabel(sessions, "public_key", never.PublicKey.String()).GetCounter().GetValue())
+
+	connected := fm["wireguard_peer_connected_seconds_total"].GetMetric()
+	assert.GreaterOrEqual(t, metricByLabel(connected, "public_key", up.PublicKey.String()).GetCounter().GetValue(), 60.0)
+	assert.Equal(t, 0.0, metricByLabel(connected, "public_key", never.PublicKey.String()).GetCounter().GetValue())
+}
@@ -0,0 +1,89 @@
+package wgprometheus
+
+import (
+	"time"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// rekeyAfterTime and rekeyTimeout mirror the WireGuard protocol constants
+// REKEY_AFTER_TIME and REKEY_TIMEOUT. A live session performs a new
+// handshake at least every rekeyAfterTime, retrying every rekeyTimeout.
+const (
+	rekeyAfterTime = 120 * time.Second
+	rekeyTimeout   = 5 * time.Second
+)
+
+// WithPeerTimeout sets the handshake age after which peers are considered
+// down. It defaults to PeerHandshakeTimeout.
+func WithPeerTimeout(d time.Duration) Option {
+	return func(c *Collector) {
+		c.peerTimeout = d
+	}
+}
+
+// WithInterfacePeerTimeouts overrides the peer timeout for the named
+// interfaces.
+func WithInterfacePeerTimeouts(timeouts map[string]time.Duration) Option {
+	return func(c *Collector) {
+		c.interfaceTimeouts = timeouts
+	}
+}
+
+// WithKeepaliveTimeout derives the timeout of peers that have a persistent
+// keepalive interval as multiplier times that interval plus the rekey
+// window. Peers without keepalive keep the interface or global timeout.
+// A multiplier of zero disables the mode.
+func WithKeepaliveTimeout(multiplier float64) Option {
+	return func(c *Collector) {
+		c.keepaliveMultiplier = multiplier
+	}
+}
+
+// handshakeTimeout returns the handshake age after which the given peer of
+// interface iface is considered down.
+func (c *Collector) handshakeTimeout(iface string, peer *wgtypes.Peer) time.Duration {
+	if c.keepaliveMultiplier > 0 && peer.PersistentKeepaliveInterval > 0 {
+		keepalive := time.Duration(c.keepaliveMultiplier * float64(peer.PersistentKeepaliveInterval))
+		return keepalive + rekeyAfterTime + rekeyTimeout
+	}
+	if d, ok := c.interfaceTimeouts[iface]; ok {
+		return d
+	}
+	return c.peerTimeout
+}
+
+// isPeerUp reports whether the peer has had a handshake within its timeout.
+func (c *Collector) isPeerUp(iface string, peer *wgtypes.Peer, now time.Time) bool {
+	if peer.LastHandshakeTime.IsZero() {
+		return false
+	}
+	return now.Sub(peer.LastHandshakeTime) < c.handshakeTimeout(iface, peer)
+}
+
+// Peer connection states reported by wireguard_peer_state.
+const (
+	peerStateActive          = "active"
+	peerStateStale           = "stale"
+	peerStateNeverHandshaked = "never_handshaked"
+	peerStateWaiting         = "waiting_for_inbound"
+)
+
+// peerStates lists every state in the order they are emitted.
+var peerStates = []string{peerStateActive, peerStateStale, peerStateNeverHandshaked, peerStateWaiting}
+
+// peerState classifies a peer. A peer that is not up and has no endpoint
+// can only be reached once it connects to us, so it is waiting for
+// inbound regardless of its handshake history.
+func (c *Collector) peerState(iface string, peer *wgtypes.Peer, now time.Time) string {
+	switch {
+	case c.isPeerUp(iface, peer, now):
+		return peerStateActive
+	case peer.Endpoint == nil:
+		return peerStateWaiting
+	case peer.LastHandshakeTime.IsZero():
+		return peerStateNeverHandshaked
+	default:
+		return peerStateStale
+	}
+}
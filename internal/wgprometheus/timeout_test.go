@@ -0,0 +1,176 @@
+package wgprometheus
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func TestHandshakeTimeout(t *testing.T) {
+	keepalivePeer := wgtypes.Peer{PersistentKeepaliveInterval: 25 * time.Second}
+	plainPeer := wgtypes.Peer{}
+
+	tests := []struct {
+		name     string
+		opts     []Option
+		iface    string
+		peer     wgtypes.Peer
+		expected time.Duration
+	}{
+		{
+			name:     "default timeout",
+			iface:    "wg0",
+			peer:     plainPeer,
+			expected: PeerHandshakeTimeout,
+		},
+		{
+			name:     "global timeout",
+			opts:     []Option{WithPeerTimeout(10 * time.Minute)},
+			iface:    "wg0",
+			peer:     plainPeer,
+			expected: 10 * time.Minute,
+		},
+		{
+			name: "interface timeout overrides global",
+			opts: []Option{
+				WithPeerTimeout(10 * time.Minute),
+				WithInterfacePeerTimeouts(map[string]time.Duration{"wg1": time.Hour}),
+			},
+			iface:    "wg1",
+			peer:     plainPeer,
+			expected: time.Hour,
+		},
+		{
+			name:     "keepalive ignored when mode disabled",
+			iface:    "wg0",
+			peer:     keepalivePeer,
+			expected: PeerHandshakeTimeout,
+		},
+		{
+			name: "keepalive derived timeout",
+			opts: []Option{
+				WithKeepaliveTimeout(3),
+				WithInterfacePeerTimeouts(map[string]time.Duration{"wg0": time.Hour}),
+			},
+			iface:    "wg0",
+			peer:     keepalivePeer,
+			expected: 75*time.Second + rekeyAfterTime + rekeyTimeout,
+		},
+		{
+			name: "keepalive mode falls back without keepalive",
+			opts: []Option{
+				WithKeepaliveTimeout(3),
+				WithInterfacePeerTimeouts(map[string]time.Duration{"wg0": time.Hour}),
+			},
+			iface:    "wg0",
+			peer:     plainPeer,
+			expected: time.Hour,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewCollectorWithDevices(nil, &mockDeviceLister{}, tt.opts...)
+			assert.Equal(t, tt.expected, c.handshakeTimeout(tt.iface, &tt.peer))
+		})
+	}
+}
+
+func TestPeerUpWithInterfaceTimeout(t *testing.T) {
+	handshake := time.Now().Add(-10 * time.Minute)
+	peer1 := newTestPeer(1, 100, 200, handshake)
+	peer2 := newTestPeer(2, 100, 200, handshake)
+
+	mock := &mockDeviceLister{
+		devices: []*wgtypes.Device{
+			{Name: "wg0", Peers: []wgtypes.Peer{peer1}},
+			{Name: "mobile0", Peers: []wgtypes.Peer{peer2}},
+		},
+	}
+
+	c := NewCollectorWithDevices(nil, mock,
+		WithInterfacePeerTimeouts(map[string]time.Duration{"mobile0": time.Hour}),
+	)
+	fm := familyMap(collectMetrics(t, c))
+
+	require.Contains(t, fm, "wireguard_peer_up")
+	up := make(map[string]float64)
+	for _, metric := range fm["wireguard_peer_up"].GetMetric() {
+		up[labelMap(metric)["interface"]] = metric.GetGauge().GetValue()
+	}
+	assert.Equal(t, 0.0, up["wg0"])
+	assert.Equal(t, 1.0, up["mobile0"])
+}
+
+func TestPeerState(t *testing.T) {
+	now := time.Now()
+	endpoint := &net.UDPAddr{IP: net.IPv4(203, 0, 113, 7), Port: 51820}
+
+	tests := []struct {
+		name     string
+		peer     wgtypes.Peer
+		expected string
+	}{
+		{
+			name:     "recent handshake",
+			peer:     wgtypes.Peer{Endpoint: endpoint, LastHandshakeTime: now.Add(-time.Minute)},
+			expected: peerStateActive,
+		},
+		{
+			name:     "recent handshake without endpoint",
+			peer:     wgtypes.Peer{LastHandshakeTime: now.Add(-time.Minute)},
+			expected: peerStateActive,
+		},
+		{
+			name:     "old handshake",
+			peer:     wgtypes.Peer{Endpoint: endpoint, LastHandshakeTime: now.Add(-time.Hour)},
+			expected: peerStateStale,
+		},
+		{
+			name:     "no handshake",
+			peer:     wgtypes.Peer{Endpoint: endpoint},
+			expected: peerStateNeverHandshaked,
+		},
+		{
+			name:     "no endpoint",
+			peer:     wgtypes.Peer{},
+			expected: peerStateWaiting,
+		},
+	}
+
+	c := NewCollectorWithDevices(nil, &mockDeviceLister{})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, c.peerState("wg0", &tt.peer, now))
+		})
+	}
+}
+
+func TestCollectPeerStateSet(t *testing.T) {
+	peer := newTestPeer(1, 100, 200, time.Unix(1000, 0))
+	peer.Endpoint = &net.UDPAddr{IP: net.IPv4(203, 0, 113, 7), Port: 51820}
+
+	mock := &mockDeviceLister{
+		devices: []*wgtypes.Device{
+			{Name: "wg0", Peers: []wgtypes.Peer{peer}},
+		},
+	}
+
+	fm := familyMap(collectMetrics(t, NewCollectorWithDevices(nil, mock)))
+
+	require.Contains(t, fm, "wireguard_peer_state")
+	states := make(map[string]float64)
+	for _, metric := range fm["wireguard_peer_state"].GetMetric() {
+		states[labelMap(metric)["wireguard_peer_state"]] = metric.GetGauge().GetValue()
+	}
+	assert.Equal(t, map[string]float64{
+		peerStateActive:          0,
+		peerStateStale:           1,
+		peerStateNeverHandshaked: 0,
+		peerStateWaiting:         0,
+	}, states)
+}
@@ -0,0 +1,56 @@
+package wgprometheus
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"slices"
+	"strings"
+)
+
+// uapiDir is where userspace WireGuard implementations, such as
+// wireguard-go, create their UAPI sockets.
+const uapiDir = "/var/run/wireguard"
+
+// wireguardNames returns the names of the WireGuard interfaces visible to
+// the calling thread: kernel links of kind "wireguard" in its network
+// namespace and userspace devices with a UAPI socket. Other links are
+// never listed, so they are never read.
+func wireguardNames() ([]string, error) {
+	names, err := kernelDeviceNames()
+	if err != nil {
+		return nil, err
+	}
+	userspace, err := userspaceDeviceNames(uapiDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range userspace {
+		if !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// userspaceDeviceNames returns the names of the devices with a UAPI socket
+// in dir. A missing dir has no devices.
+func userspaceDeviceNames(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if e.Type()&fs.ModeSocket == 0 {
+			continue
+		}
+		if name, ok := strings.CutSuffix(e.Name(), ".sock"); ok {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
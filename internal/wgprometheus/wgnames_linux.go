@@ -0,0 +1,82 @@
+package wgprometheus
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// wireguardKind is the IFLA_INFO_KIND of WireGuard links.
+const wireguardKind = "wireguard"
+
+// kernelDeviceNames returns the names of the WireGuard links in the
+// network namespace of the calling thread. Dumping links needs no
+// privileges.
+func kernelDeviceNames() ([]string, error) {
+	tab, err := syscall.NetlinkRIB(syscall.RTM_GETLINK, syscall.AF_UNSPEC)
+	if err != nil {
+		return nil, fmt.Errorf("listing network links: %w", err)
+	}
+	msgs, err := syscall.ParseNetlinkMessage(tab)
+	if err != nil {
+		return nil, fmt.Errorf("parsing network links: %w", err)
+	}
+	return wireguardLinks(msgs)
+}
+
+// wireguardLinks returns the names of the WireGuard links among the
+// RTM_NEWLINK messages of a link dump.
+func wireguardLinks(msgs []syscall.NetlinkMessage) ([]string, error) {
+	var names []string
+	for i := range msgs {
+		if msgs[i].Header.Type != syscall.RTM_NEWLINK {
+			continue
+		}
+		attrs, err := syscall.ParseNetlinkRouteAttr(&msgs[i])
+		if err != nil {
+			return nil, fmt.Errorf("parsing network link: %w", err)
+		}
+		var name, kind string
+		for _, a := range attrs {
+			switch attrType(a.Attr.Type) {
+			case syscall.IFLA_IFNAME:
+				name = string(bytes.TrimRight(a.Value, "\x00"))
+			case syscall.IFLA_LINKINFO:
+				kind = linkKind(a.Value)
+			}
+		}
+		if kind == wireguardKind {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// linkKind returns the IFLA_INFO_KIND among the nested attributes of an
+// IFLA_LINKINFO attribute.
+func linkKind(b []byte) string {
+	for len(b) >= syscall.SizeofRtAttr {
+		length := int(binary.NativeEndian.Uint16(b[0:2]))
+		typ := attrType(binary.NativeEndian.Uint16(b[2:4]))
+		if length < syscall.SizeofRtAttr || length > len(b) {
+			return ""
+		}
+		if typ == unix.IFLA_INFO_KIND {
+			return string(bytes.TrimRight(b[syscall.SizeofRtAttr:length], "\x00"))
+		}
+		b = b[min(rtaAlign(length), len(b)):]
+	}
+	return ""
+}
+
+// attrType strips the nested and byte order flags from an attribute type.
+func attrType(typ uint16) uint16 {
+	return typ &^ (unix.NLA_F_NESTED | unix.NLA_F_NET_BYTEORDER)
+}
+
+func rtaAlign(n int) int {
+	return (n + syscall.RTA_ALIGNTO - 1) &^ (syscall.RTA_ALIGNTO - 1)
+}
@@ -0,0 +1,48 @@
+package wgprometheus
+
+import (
+	"encoding/binary"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+// rtAttr encodes a route attribute, padded to the attribute alignment.
+func rtAttr(typ uint16, value []byte) []byte {
+	b := make([]byte, syscall.SizeofRtAttr, rtaAlign(syscall.SizeofRtAttr+len(value)))
+	binary.NativeEndian.PutUint16(b[0:2], uint16(syscall.SizeofRtAttr+len(value)))
+	binary.NativeEndian.PutUint16(b[2:4], typ)
+	b = append(b, value...)
+	return b[:cap(b)]
+}
+
+func linkMessage(name, kind string) syscall.NetlinkMessage {
+	data := make([]byte, syscall.SizeofIfInfomsg)
+	data = append(data, rtAttr(syscall.IFLA_IFNAME, append([]byte(name), 0))...)
+	if kind != "" {
+		info := rtAttr(unix.IFLA_INFO_KIND, append([]byte(kind), 0))
+		data = append(data, rtAttr(syscall.IFLA_LINKINFO|unix.NLA_F_NESTED, info)...)
+	}
+	return syscall.NetlinkMessage{Header: syscall.NlMsghdr{Type: syscall.RTM_NEWLINK}, Data: data}
+}
+
+func TestWireguardLinks(t *testing.T) {
+	names, err := wireguardLinks([]syscall.NetlinkMessage{
+		linkMessage("lo", ""),
+		linkMessage("wg0", "wireguard"),
+		linkMessage("docker0", "bridge"),
+		linkMessage("wg-office", "wireguard"),
+		{Header: syscall.NlMsghdr{Type: syscall.NLMSG_DONE}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"wg0", "wg-office"}, names)
+}
+
+func TestKernelDeviceNamesSkipsOtherLinks(t *testing.T) {
+	names, err := kernelDeviceNames()
+	require.NoError(t, err)
+	assert.NotContains(t, names, "lo")
+}
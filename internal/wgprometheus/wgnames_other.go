@@ -0,0 +1,30 @@
+//go:build !linux
+
+package wgprometheus
+
+import (
+	"golang.zx2c4.com/wireguard/wgctrl"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// kernelDeviceNames returns the names of the kernel WireGuard devices,
+// which only wgctrl knows how to find outside Linux.
+func kernelDeviceNames() ([]string, error) {
+	client, err := wgctrl.New()
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	devices, err := client.Devices()
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, dev := range devices {
+		if dev.Type != wgtypes.Userspace {
+			names = append(names, dev.Name)
+		}
+	}
+	return names, nil
+}
@@ -0,0 +1,31 @@
+package wgprometheus
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUserspaceDeviceNames(t *testing.T) {
+	dir, err := os.MkdirTemp("", "uapi")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	ln, err := net.Listen("unix", filepath.Join(dir, "wg0.sock"))
+	require.NoError(t, err)
+	defer ln.Close()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "wg1.sock"), nil, 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "wg2.name"), nil, 0o600))
+
+	names, err := userspaceDeviceNames(dir)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"wg0"}, names)
+
+	names, err = userspaceDeviceNames(filepath.Join(dir, "missing"))
+	require.NoError(t, err)
+	assert.Empty(t, names)
+}
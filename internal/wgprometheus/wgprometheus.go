@@ -1,49 +1,46 @@
 package wgprometheus
 
 import (
-	"fmt"
+	"context"
+	"errors"
+	"io"
 	"log/slog"
+	"math"
+	"net"
+	"net/netip"
+	"os"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/sathiraumesh/wireguard_exporter/internal/accounting"
+	"github.com/sathiraumesh/wireguard_exporter/internal/peermeta"
+	"github.com/sathiraumesh/wireguard_exporter/internal/quota"
+	"github.com/sathiraumesh/wireguard_exporter/internal/wgquick"
+	"golang.org/x/sync/singleflight"
 	"golang.zx2c4.com/wireguard/wgctrl"
 	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
 )
 
-var peerLabels = []string{"interface", "public_key", "allowed_ips"}
+var interfaceLabels = []string{"interface"}
 
-var (
-	handshakeDesc = prometheus.NewDesc(
-		"wireguard_latest_handshake_seconds",
-		"Unix timestamp of the latest handshake for a WireGuard peer.",
-		peerLabels, nil,
-	)
-
-	transmitDesc = prometheus.NewDesc(
-		"wireguard_transmitted_bytes",
-		"Total bytes transmitted to a WireGuard peer.",
-		peerLabels, nil,
-	)
+// AllowedIPsMode selects how the allowed IPs of a peer are exported.
+type AllowedIPsMode string
 
-	receivedDesc = prometheus.NewDesc(
-		"wireguard_received_bytes",
-		"Total bytes received from a WireGuard peer.",
-		peerLabels, nil,
-	)
-
-	peerUpDesc = prometheus.NewDesc(
-		"wireguard_peer_up",
-		"Whether a WireGuard peer has had a recent handshake (1 = up, 0 = down).",
-		peerLabels, nil,
-	)
-
-	interfaceInfoDesc = prometheus.NewDesc(
-		"wireguard_interface_info",
-		"Information about a WireGuard interface.",
-		[]string{"interface", "public_key", "listen_port"}, nil,
-	)
+const (
+	// AllowedIPsLabel adds an allowed_ips label to every peer metric.
+	AllowedIPsLabel AllowedIPsMode = "label"
+	// AllowedIPsInfo emits one wireguard_peer_allowed_ip_info series per
+	// prefix instead, so route changes do not churn the other peer series.
+	AllowedIPsInfo AllowedIPsMode = "info"
+)
 
+var (
 	scrapeSuccessDesc = prometheus.NewDesc(
 		"wireguard_scrape_success",
 		"Whether the last scrape of WireGuard metrics was successful (1 = success, 0 = failure).",
@@ -55,10 +52,280 @@ var (
 		"Duration of the last WireGuard metrics scrape in seconds.",
 		nil, nil,
 	)
+
+	scrapeTimedOutDesc = prometheus.NewDesc(
+		"wireguard_scrape_timed_out",
+		"Whether the last read of WireGuard devices hit its deadline, leaving the results partial (1 = timed out).",
+		nil, nil,
+	)
+)
+
+// interfaceDescs holds the descriptors of the per-interface metrics.
+type interfaceDescs struct {
+	info             *prometheus.Desc
+	peers            *prometheus.Desc
+	peersUp          *prometheus.Desc
+	peersNoHandshake *prometheus.Desc
+	firewallMark     *prometheus.Desc
+	scrapeSuccess    *prometheus.Desc
+	scrapeDuration   *prometheus.Desc
+	scrapeErrors     *prometheus.Desc
+	peersTruncated   *prometheus.Desc
+}
+
+func newInterfaceDescs(labels []string) *interfaceDescs {
+	return &interfaceDescs{
+		info: prometheus.NewDesc(
+			"wireguard_interface_info",
+			"Information about a WireGuard interface.",
+			append(slices.Clone(labels), "public_key", "listen_port", "device_type"), nil,
+		),
+		peers: prometheus.NewDesc(
+			"wireguard_interface_peers",
+			"Number of peers configured on a WireGuard interface that pass the peer filters.",
+			labels, nil,
+		),
+		peersUp: prometheus.NewDesc(
+			"wireguard_interface_peers_up",
+			"Number of peers on a WireGuard interface that are currently up.",
+			labels, nil,
+		),
+		peersNoHandshake: prometheus.NewDesc(
+			"wireguard_interface_peers_never_handshaked",
+			"Number of peers on a WireGuard interface that have never completed a handshake.",
+			labels, nil,
+		),
+		firewallMark: prometheus.NewDesc(
+			"wireguard_interface_firewall_mark",
+			"Firewall mark applied to packets of a WireGuard interface (0 = unset).",
+			labels, nil,
+		),
+		scrapeSuccess: prometheus.NewDesc(
+			"wireguard_interface_scrape_success",
+			"Whether the last read of a WireGuard interface was successful (1 = success, 0 = failure).",
+			labels, nil,
+		),
+		scrapeDuration: prometheus.NewDesc(
+			"wireguard_interface_scrape_duration_seconds",
+			"Duration of the last read of a WireGuard interface in seconds.",
+			labels, nil,
+		),
+		scrapeErrors: prometheus.NewDesc(
+			"wireguard_interface_scrape_errors_total",
+			"Total number of failed reads of a WireGuard interface by error class.",
+			append(slices.Clone(labels), "class"), nil,
+		),
+		peersTruncated: prometheus.NewDesc(
+			"wireguard_exporter_peers_truncated",
+			"Number of peers on a WireGuard interface whose per-peer series were dropped by the peer limits.",
+			labels, nil,
+		),
+	}
+}
+
+func (d *interfaceDescs) describe(ch chan<- *prometheus.Desc) {
+	ch <- d.info
+	ch <- d.peers
+	ch <- d.peersUp
+	ch <- d.peersNoHandshake
+	ch <- d.firewallMark
+	ch <- d.scrapeSuccess
+	ch <- d.scrapeDuration
+	ch <- d.scrapeErrors
+	ch <- d.peersTruncated
+}
+
+// NeverHandshakeMode selects how wireguard_peer_handshake_age_seconds
+// reports peers that have never completed a handshake.
+type NeverHandshakeMode string
+
+const (
+	// NeverHandshakeOmit leaves such peers out of the metric.
+	NeverHandshakeOmit NeverHandshakeMode = "omit"
+	// NeverHandshakeInf reports such peers as +Inf.
+	NeverHandshakeInf NeverHandshakeMode = "inf"
 )
 
-// PeerHandshakeTimeout is the duration after which a peer is considered down
-// if no handshake has occurred.
+// peerDescs holds the descriptors of all per-peer metrics for one set of
+// label names. The set changes when peer metadata adds extra labels.
+type peerDescs struct {
+	labels        []string
+	handshake     *prometheus.Desc
+	handshakeAge  *prometheus.Desc
+	transmit      *prometheus.Desc
+	received      *prometheus.Desc
+	transmitTotal *prometheus.Desc
+	receiveTotal  *prometheus.Desc
+	peerUp        *prometheus.Desc
+	peerState     *prometheus.Desc
+	endpoint      *prometheus.Desc
+	keepalive     *prometheus.Desc
+	protocol      *prometheus.Desc
+	allowedIPs    *prometheus.Desc
+	allowedIPInfo *prometheus.Desc
+	transmitRate  *prometheus.Desc
+	receiveRate   *prometheus.Desc
+	transmitCum   *prometheus.Desc
+	receiveCum    *prometheus.Desc
+	quota         *prometheus.Desc
+	quotaUsed     *prometheus.Desc
+	firstSeen     *prometheus.Desc
+	sessionStart  *prometheus.Desc
+	sessions      *prometheus.Desc
+	connected     *prometheus.Desc
+}
+
+func newPeerDescs(labels []string) *peerDescs {
+	return &peerDescs{
+		labels: labels,
+		handshake: prometheus.NewDesc(
+			"wireguard_latest_handshake_seconds",
+			"Unix timestamp of the latest handshake for a WireGuard peer.",
+			labels, nil,
+		),
+		handshakeAge: prometheus.NewDesc(
+			"wireguard_peer_handshake_age_seconds",
+			"Seconds since the latest handshake of a WireGuard peer, measured by the exporter at scrape time.",
+			labels, nil,
+		),
+		transmit: prometheus.NewDesc(
+			"wireguard_transmitted_bytes",
+			"Total bytes transmitted to a WireGuard peer. Deprecated: use wireguard_peer_transmit_bytes_total.",
+			labels, nil,
+		),
+		received: prometheus.NewDesc(
+			"wireguard_received_bytes",
+			"Total bytes received from a WireGuard peer. Deprecated: use wireguard_peer_receive_bytes_total.",
+			labels, nil,
+		),
+		transmitTotal: prometheus.NewDesc(
+			"wireguard_peer_transmit_bytes_total",
+			"Total bytes transmitted to a WireGuard peer.",
+			labels, nil,
+		),
+		receiveTotal: prometheus.NewDesc(
+			"wireguard_peer_receive_bytes_total",
+			"Total bytes received from a WireGuard peer.",
+			labels, nil,
+		),
+		transmitRate: prometheus.NewDesc(
+			"wireguard_peer_transmit_bytes_per_second",
+			"Bytes per second transmitted to a WireGuard peer between the last two device reads.",
+			labels, nil,
+		),
+		receiveRate: prometheus.NewDesc(
+			"wireguard_peer_receive_bytes_per_second",
+			"Bytes per second received from a WireGuard peer between the last two device reads.",
+			labels, nil,
+		),
+		transmitCum: prometheus.NewDesc(
+			"wireguard_peer_cumulative_transmit_bytes_total",
+			"Total bytes transmitted to a WireGuard peer, kept across interface and exporter restarts.",
+			labels, nil,
+		),
+		receiveCum: prometheus.NewDesc(
+			"wireguard_peer_cumulative_receive_bytes_total",
+			"Total bytes received from a WireGuard peer, kept across interface and exporter restarts.",
+			labels, nil,
+		),
+		quota: prometheus.NewDesc(
+			"wireguard_peer_quota_bytes",
+			"Traffic quota of a WireGuard peer for the current billing window in bytes.",
+			labels, nil,
+		),
+		quotaUsed: prometheus.NewDesc(
+			"wireguard_peer_quota_used_ratio",
+			"Share of its traffic quota a WireGuard peer has used in the current billing window (1 = quota reached).",
+			labels, nil,
+		),
+		firstSeen: prometheus.NewDesc(
+			"wireguard_peer_first_seen_timestamp_seconds",
+			"Unix timestamp at which the exporter first saw a WireGuard peer.",
+			labels, nil,
+		),
+		sessionStart: prometheus.NewDesc(
+			"wireguard_peer_session_start_timestamp_seconds",
+			"Unix timestamp of the handshake that began the current session of a WireGuard peer. Absent while the peer is down.",
+			labels, nil,
+		),
+		sessions: prometheus.NewDesc(
+			"wireguard_peer_sessions_total",
+			"Number of sessions a WireGuard peer has started, each after a period of being down.",
+			labels, nil,
+		),
+		connected: prometheus.NewDesc(
+			"wireguard_peer_connected_seconds_total",
+			"Total seconds a WireGuard peer has been up.",
+			labels, nil,
+		),
+		peerUp: prometheus.NewDesc(
+			"wireguard_peer_up",
+			"Whether a WireGuard peer has had a recent handshake (1 = up, 0 = down).",
+			labels, nil,
+		),
+		peerState: prometheus.NewDesc(
+			"wireguard_peer_state",
+			"Connection state of a WireGuard peer as an OpenMetrics StateSet; exactly one state is 1.",
+			append(slices.Clone(labels), "wireguard_peer_state"), nil,
+		),
+		endpoint: prometheus.NewDesc(
+			"wireguard_peer_endpoint_info",
+			"Current endpoint of a WireGuard peer. Absent for peers without a known endpoint.",
+			append(slices.Clone(labels), "endpoint_ip", "endpoint_port", "family"), nil,
+		),
+		keepalive: prometheus.NewDesc(
+			"wireguard_peer_persistent_keepalive_seconds",
+			"Persistent keepalive interval of a WireGuard peer in seconds (0 = disabled).",
+			labels, nil,
+		),
+		protocol: prometheus.NewDesc(
+			"wireguard_peer_protocol_version",
+			"WireGuard protocol version used by a peer.",
+			labels, nil,
+		),
+		allowedIPs: prometheus.NewDesc(
+			"wireguard_peer_allowed_ips_count",
+			"Number of allowed IP prefixes configured for a WireGuard peer.",
+			labels, nil,
+		),
+		allowedIPInfo: prometheus.NewDesc(
+			"wireguard_peer_allowed_ip_info",
+			"Allowed IP prefix of a WireGuard peer, one series per prefix.",
+			append(slices.Clone(labels), "prefix", "family"), nil,
+		),
+	}
+}
+
+func (d *peerDescs) describe(ch chan<- *prometheus.Desc, legacyByteGauges bool) {
+	ch <- d.handshake
+	ch <- d.handshakeAge
+	if legacyByteGauges {
+		ch <- d.transmit
+		ch <- d.received
+	}
+	ch <- d.transmitTotal
+	ch <- d.receiveTotal
+	ch <- d.transmitRate
+	ch <- d.receiveRate
+	ch <- d.transmitCum
+	ch <- d.receiveCum
+	ch <- d.quota
+	ch <- d.quotaUsed
+	ch <- d.firstSeen
+	ch <- d.sessionStart
+	ch <- d.sessions
+	ch <- d.connected
+	ch <- d.peerUp
+	ch <- d.peerState
+	ch <- d.endpoint
+	ch <- d.keepalive
+	ch <- d.protocol
+	ch <- d.allowedIPs
+	ch <- d.allowedIPInfo
+}
+
+// PeerHandshakeTimeout is the default duration after which a peer is
+// considered down if no handshake has occurred.
 const PeerHandshakeTimeout = 5 * time.Minute
 
 // DeviceLister abstracts WireGuard device enumeration for testability.
@@ -66,118 +333,662 @@ type DeviceLister interface {
 	Devices() ([]*wgtypes.Device, error)
 }
 
-// wgDeviceLister creates a new wgctrl client per call.
-type wgDeviceLister struct{}
+// wgDeviceLister keeps a pool of wgctrl clients, one per concurrent read,
+// and reuses them across scrapes. A client is closed after an error, and
+// a client whose call is abandoned because its context is done is closed
+// once the call returns, so that a wedged client is never reused. While
+// an abandoned call is still running, its interface is not read again.
+type wgDeviceLister struct {
+	mu     sync.Mutex
+	idle   []*wgctrl.Client
+	closed bool
+
+	pending pendingCalls
+}
+
+func (w *wgDeviceLister) DeviceNames(ctx context.Context) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return wireguardNames()
+}
 
-func (w *wgDeviceLister) Devices() ([]*wgtypes.Device, error) {
-	client, err := wgctrl.New()
+func (w *wgDeviceLister) Device(ctx context.Context, name string) (*wgtypes.Device, error) {
+	if !w.pending.start(name) {
+		return nil, errStillRunning
+	}
+	client, err := w.acquire()
 	if err != nil {
+		w.pending.done(name)
 		return nil, err
 	}
-	defer client.Close()
-	return client.Devices()
+
+	type result struct {
+		dev *wgtypes.Device
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		dev, err := client.Device(name)
+		w.pending.done(name)
+		done <- result{dev, err}
+	}()
+
+	select {
+	case r := <-done:
+		if r.err != nil && !errors.Is(r.err, os.ErrNotExist) {
+			client.Close()
+		} else {
+			w.release(client)
+		}
+		return r.dev, r.err
+	case <-ctx.Done():
+		go func() {
+			<-done
+			client.Close()
+		}()
+		return nil, ctx.Err()
+	}
+}
+
+// acquire takes an idle client from the pool or opens a new one.
+func (w *wgDeviceLister) acquire() (*wgctrl.Client, error) {
+	w.mu.Lock()
+	if n := len(w.idle); n > 0 {
+		client := w.idle[n-1]
+		w.idle = w.idle[:n-1]
+		w.mu.Unlock()
+		return client, nil
+	}
+	w.mu.Unlock()
+	return wgctrl.New()
+}
+
+// release returns client to the pool, or closes it if the lister is
+// closed.
+func (w *wgDeviceLister) release(client *wgctrl.Client) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		client.Close()
+		return
+	}
+	w.idle = append(w.idle, client)
+}
+
+// Close releases the pooled wgctrl clients.
+func (w *wgDeviceLister) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.closed = true
+	var errs []error
+	for _, client := range w.idle {
+		errs = append(errs, client.Close())
+	}
+	w.idle = nil
+	return errors.Join(errs...)
 }
 
 // Collector implements prometheus.Collector and fetches WireGuard
 // metrics on each Prometheus scrape.
 type Collector struct {
-	devices    DeviceLister
+	devices    InterfaceLister
+	namespaces NamespaceLister
 	monitorSet map[string]struct{}
+
+	containerLabels bool
+
+	includePatterns []NamePattern
+	excludePatterns []NamePattern
+
+	peerAllow map[wgtypes.Key]struct{}
+	peerDeny  map[wgtypes.Key]struct{}
+	peerCIDRs []netip.Prefix
+
+	metadata *peermeta.Source
+	wgQuick  *wgquick.Dir
+
+	legacyByteGauges bool
+	allowedIPsMode   AllowedIPsMode
+	neverHandshake   NeverHandshakeMode
+
+	peerTimeout         time.Duration
+	interfaceTimeouts   map[string]time.Duration
+	keepaliveMultiplier float64
+
+	ifaceDescs *interfaceDescs
+
+	maxPeersPerInterface int
+	maxTotalPeers        int
+	peerLimitMode        PeerLimitMode
+
+	inflight      singleflight.Group
+	poller        *poller
+	workers       int
+	scrapeTimeout time.Duration
+
+	// labelMu guards labelCache, the per-peer labels reused across scrapes.
+	labelMu    sync.Mutex
+	labelCache map[peerLabelKey]*peerLabels
+	scrapeGen  atomic.Uint64
+	// warnedMeta is the metadata whose reserved labels were last warned
+	// about.
+	warnedMeta atomic.Pointer[peermeta.Metadata]
+
+	// errMu guards scrapeErrors, keyed by the joined label values.
+	errMu        sync.Mutex
+	scrapeErrors map[string]*scrapeErrorCount
+
+	// knownMu guards knownIfaces, the interfaces that have been read as
+	// WireGuard devices, keyed by the joined label values.
+	knownMu     sync.Mutex
+	knownIfaces map[string]struct{}
+
+	// rates keeps the previous byte counters of every peer.
+	rates           rateTracker
+	counters        *CounterState
+	accounting      *accounting.Store
+	accountingState *CounterState
+	quotas          *quota.Source
+	sessions        *sessionTracker
+
+	// descMu guards descs, which is rebuilt when the peer label set changes.
+	descMu sync.Mutex
+	descs  *peerDescs
+}
+
+// Option configures optional Collector behaviour.
+type Option func(*Collector)
+
+// WithPeerMetadata attaches the name and extra labels from src to every
+// peer metric. Peers missing from the metadata are named by public key.
+func WithPeerMetadata(src *peermeta.Source) Option {
+	return func(c *Collector) {
+		c.metadata = src
+	}
+}
+
+// WithWGQuickNames adds a friendly_name label to peer metrics, taken from
+// "# Name = ..." comments in the wg-quick config of each interface.
+func WithWGQuickNames(dir *wgquick.Dir) Option {
+	return func(c *Collector) {
+		c.wgQuick = dir
+	}
+}
+
+// WithLegacyByteGauges controls whether the deprecated
+// wireguard_transmitted_bytes and wireguard_received_bytes gauges are
+// emitted alongside the byte counters. They are enabled by default.
+func WithLegacyByteGauges(enabled bool) Option {
+	return func(c *Collector) {
+		c.legacyByteGauges = enabled
+	}
+}
+
+// WithAllowedIPsMode selects how peer allowed IPs are exported. It defaults
+// to AllowedIPsLabel.
+func WithAllowedIPsMode(mode AllowedIPsMode) Option {
+	return func(c *Collector) {
+		c.allowedIPsMode = mode
+	}
+}
+
+// WithNeverHandshakeMode selects how peers that never completed a handshake
+// appear in wireguard_peer_handshake_age_seconds. It defaults to
+// NeverHandshakeOmit.
+func WithNeverHandshakeMode(mode NeverHandshakeMode) Option {
+	return func(c *Collector) {
+		c.neverHandshake = mode
+	}
 }
 
 // NewCollector creates a Collector that monitors the given interfaces.
 // If monitorKeys is empty, all WireGuard interfaces are monitored.
-func NewCollector(monitorKeys []string) *Collector {
+func NewCollector(monitorKeys []string, opts ...Option) *Collector {
 	set := make(map[string]struct{}, len(monitorKeys))
 	for _, key := range monitorKeys {
 		set[strings.TrimSpace(key)] = struct{}{}
 	}
-	return &Collector{
+	c := &Collector{
 		devices:    &wgDeviceLister{},
 		monitorSet: set,
+
+		legacyByteGauges: true,
+		allowedIPsMode:   AllowedIPsLabel,
+		neverHandshake:   NeverHandshakeOmit,
+		peerTimeout:      PeerHandshakeTimeout,
+		workers:          DefaultWorkers,
+		scrapeTimeout:    DefaultScrapeTimeout,
+		peerLimitMode:    PeerLimitDrop,
+
+		scrapeErrors: make(map[string]*scrapeErrorCount),
+		knownIfaces:  make(map[string]struct{}),
+		labelCache:   make(map[peerLabelKey]*peerLabels),
+	}
+	for _, opt := range opts {
+		opt(c)
 	}
+	c.ifaceDescs = newInterfaceDescs(c.interfaceLabels())
+	c.descs = newPeerDescs(c.staticPeerLabels())
+	return c
 }
 
 // NewCollectorWithDevices creates a Collector with a custom DeviceLister,
-// useful for testing.
-func NewCollectorWithDevices(monitorKeys []string, devices DeviceLister) *Collector {
-	c := NewCollector(monitorKeys)
+// useful for testing. The lister is wrapped with FromDeviceLister.
+func NewCollectorWithDevices(monitorKeys []string, devices DeviceLister, opts ...Option) *Collector {
+	return NewCollectorWithInterfaces(monitorKeys, FromDeviceLister(devices), opts...)
+}
+
+// NewCollectorWithInterfaces creates a Collector with a custom
+// InterfaceLister.
+func NewCollectorWithInterfaces(monitorKeys []string, devices InterfaceLister, opts ...Option) *Collector {
+	c := NewCollector(monitorKeys, opts...)
 	c.devices = devices
 	return c
 }
 
+// interfaceLabels returns the labels identifying an interface, which are
+// shared by interface and peer metrics.
+func (c *Collector) interfaceLabels() []string {
+	labels := slices.Clone(interfaceLabels)
+	if c.namespaces != nil {
+		labels = append(labels, "netns")
+	}
+	if c.containerLabels {
+		labels = append(labels, "container", "container_id")
+	}
+	return labels
+}
+
+// staticPeerLabels returns the peer label names fixed by configuration.
+func (c *Collector) staticPeerLabels() []string {
+	labels := append(c.interfaceLabels(), "public_key")
+	if c.allowedIPsMode == AllowedIPsLabel {
+		labels = append(labels, "allowed_ips")
+	}
+	if c.wgQuick != nil {
+		labels = append(labels, "friendly_name")
+	}
+	if c.metadata != nil {
+		labels = append(labels, "name")
+	}
+	return labels
+}
+
+// peerDescsFor returns descriptors for the static peer labels followed by
+// the given metadata label keys, reusing the previous set when unchanged.
+func (c *Collector) peerDescsFor(extra []string) *peerDescs {
+	labels := append(c.staticPeerLabels(), extra...)
+
+	c.descMu.Lock()
+	defer c.descMu.Unlock()
+	if !slices.Equal(c.descs.labels, labels) {
+		c.descs = newPeerDescs(labels)
+	}
+	return c.descs
+}
+
 func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
-	ch <- handshakeDesc
-	ch <- transmitDesc
-	ch <- receivedDesc
-	ch <- peerUpDesc
-	ch <- interfaceInfoDesc
+	// Peer metadata can add arbitrary labels at runtime, so the collector
+	// stays unchecked when a metadata file is configured.
+	if c.metadata != nil {
+		return
+	}
+	c.descs.describe(ch, c.legacyByteGauges)
+	c.ifaceDescs.describe(ch)
 	ch <- scrapeSuccessDesc
 	ch <- scrapeDurationDesc
+	ch <- scrapeTimedOutDesc
+	if c.poller != nil {
+		ch <- snapshotAgeDesc
+	}
 }
 
+// Close releases resources held by the device listers, such as the
+// long-lived wgctrl client, and saves the cumulative counters. The
+// accounting counter state is saved when the accounting store is closed.
+func (c *Collector) Close() error {
+	var errs []error
+	for _, l := range []any{c.devices, c.namespaces} {
+		if closer, ok := l.(io.Closer); ok {
+			errs = append(errs, closer.Close())
+		}
+	}
+	if c.counters != nil {
+		errs = append(errs, c.counters.Save())
+	}
+	return errors.Join(errs...)
+}
+
+// Collect reads devices for at most the scrape timeout; use WithContext to
+// bound a scrape further.
 func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.collect(context.Background(), ch)
+}
+
+// WithContext returns a view of the collector whose scrapes give up on
+// WireGuard reads once ctx is done, exporting whatever was read by then.
+// Register it with a per-request registry.
+func (c *Collector) WithContext(ctx context.Context) prometheus.Collector {
+	return &contextCollector{Collector: c, ctx: ctx}
+}
+
+type contextCollector struct {
+	*Collector
+	ctx context.Context
+}
+
+func (cc *contextCollector) Collect(ch chan<- prometheus.Metric) {
+	cc.collect(cc.ctx, ch)
+}
+
+func (c *Collector) collect(ctx context.Context, ch chan<- prometheus.Metric) {
 	start := time.Now()
 
-	devices, err := c.devices.Devices()
-	if err != nil {
-		slog.Error("failed to list WireGuard devices", "error", err)
+	var snap snapshot
+	if c.poller != nil {
+		snap = c.poller.get()
+		ch <- prometheus.MustNewConstMetric(snapshotAgeDesc, prometheus.GaugeValue, start.Sub(snap.taken).Seconds())
+	} else {
+		snap = c.fetchDevices(ctx)
+		if snap.err != nil {
+			slog.Error("failed to list WireGuard devices", "error", snap.err)
+		}
+	}
+	devices := snap.devices
+	timedOut := 0.0
+	if snap.timedOut {
+		timedOut = 1
+	}
+	ch <- prometheus.MustNewConstMetric(scrapeTimedOutDesc, prometheus.GaugeValue, timedOut)
+	if snap.err != nil {
 		ch <- prometheus.MustNewConstMetric(scrapeSuccessDesc, prometheus.GaugeValue, 0)
 		ch <- prometheus.MustNewConstMetric(scrapeDurationDesc, prometheus.GaugeValue, time.Since(start).Seconds())
 		return
 	}
 
-	for _, dev := range devices {
-		if !c.shouldMonitor(dev.Name) {
+	s := &scrape{ch: ch, now: start, gen: c.scrapeGen.Add(1), rates: snap.rates, totals: snap.totals, sessions: snap.sessions}
+	if c.metadata != nil {
+		s.meta = c.metadata.Metadata()
+		s.metaKeys = c.metadataLabelKeys(s.meta)
+	}
+	s.descs = c.peerDescsFor(s.metaKeys)
+	c.loadQuotas(s)
+
+	var scraped []*deviceScrape
+	monitored := 0
+	for _, nd := range devices {
+		if !c.shouldMonitor(nd.Name) {
 			continue
 		}
-
-		ch <- prometheus.MustNewConstMetric(
-			interfaceInfoDesc, prometheus.GaugeValue, 1,
-			dev.Name, dev.PublicKey.String(), fmt.Sprintf("%d", dev.ListenPort),
-		)
-
-		for _, peer := range dev.Peers {
-			ifName := dev.Name
-			pubKey := peer.PublicKey.String()
-			allowedIPs := fmt.Sprintf("%v", peer.AllowedIPs)
-
-			ch <- prometheus.MustNewConstMetric(
-				handshakeDesc, prometheus.GaugeValue,
-				float64(peer.LastHandshakeTime.Unix()),
-				ifName, pubKey, allowedIPs,
-			)
-			ch <- prometheus.MustNewConstMetric(
-				transmitDesc, prometheus.GaugeValue,
-				float64(peer.TransmitBytes),
-				ifName, pubKey, allowedIPs,
-			)
-			ch <- prometheus.MustNewConstMetric(
-				receivedDesc, prometheus.GaugeValue,
-				float64(peer.ReceiveBytes),
-				ifName, pubKey, allowedIPs,
-			)
-
-			up := 0.0
-			if !peer.LastHandshakeTime.IsZero() && time.Since(peer.LastHandshakeTime) < PeerHandshakeTimeout {
-				up = 1.0
-			}
-			ch <- prometheus.MustNewConstMetric(
-				peerUpDesc, prometheus.GaugeValue,
-				up,
-				ifName, pubKey, allowedIPs,
-			)
+		monitored++
+		ifaceValues := c.interfaceValues(nd)
+		success := 0.0
+		if nd.Err == nil {
+			success = 1
+			scraped = append(scraped, c.newDeviceScrape(nd, ifaceValues))
 		}
+		ch <- prometheus.MustNewConstMetric(c.ifaceDescs.scrapeSuccess, prometheus.GaugeValue, success, ifaceValues...)
+		ch <- prometheus.MustNewConstMetric(c.ifaceDescs.scrapeDuration, prometheus.GaugeValue, nd.Duration.Seconds(), ifaceValues...)
 	}
+	c.applyPeerLimits(scraped)
+	for _, d := range scraped {
+		c.collectDevice(s, d)
+	}
+	c.collectErrors(ch)
+	c.sweepPeerLabels(s.gen)
 
-	ch <- prometheus.MustNewConstMetric(scrapeSuccessDesc, prometheus.GaugeValue, 1)
+	// A scrape in which every monitored interface failed to read exported
+	// nothing, so it fails as a whole.
+	success := 1.0
+	if monitored > 0 && len(scraped) == 0 {
+		success = 0
+	}
+	ch <- prometheus.MustNewConstMetric(scrapeSuccessDesc, prometheus.GaugeValue, success)
 	ch <- prometheus.MustNewConstMetric(scrapeDurationDesc, prometheus.GaugeValue, time.Since(start).Seconds())
 }
 
-func (c *Collector) shouldMonitor(name string) bool {
-	if len(c.monitorSet) == 0 {
-		return true
+// scrape holds the state shared by all devices during one Collect call.
+type scrape struct {
+	ch       chan<- prometheus.Metric
+	now      time.Time
+	gen      uint64
+	descs    *peerDescs
+	meta     *peermeta.Metadata
+	metaKeys []string
+	rates    map[peerLabelKey]peerRate
+	totals   map[peerLabelKey]byteTotals
+	sessions map[peerLabelKey]peerSession
+
+	quotas     *quota.Config
+	quotaUsage map[accounting.Peer]accounting.Usage
+}
+
+// listDevices returns the devices of every scanned namespace, or of the
+// exporter's own namespace when namespace scanning is disabled.
+func (c *Collector) listDevices(ctx context.Context) ([]NamespacedDevice, error) {
+	if c.namespaces != nil {
+		return c.namespaces.NamespacedDevices(ctx, DeviceFilter{Monitor: c.shouldMonitor, Required: c.monitorSet})
+	}
+	names, err := c.devices.DeviceNames(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return readDevices(ctx, c.devices, c.withRequiredNames(names), c.monitorSet, c.shouldMonitor, c.workers), nil
+}
+
+// interfaceValues returns the interface label values of nd.
+func (c *Collector) interfaceValues(nd NamespacedDevice) []string {
+	values := []string{nd.Name}
+	if c.namespaces != nil {
+		values = append(values, nd.Namespace)
+	}
+	if c.containerLabels {
+		values = append(values, nd.ContainerName, nd.ContainerID)
+	}
+	return values
+}
+
+// stateValues identify the interface of nd in counter state. Unlike the
+// interface labels they leave out the container ID, and the namespace of
+// a container is replaced by the container name: both change whenever the
+// container is recreated.
+func (c *Collector) stateValues(nd NamespacedDevice) []string {
+	values := []string{nd.Name}
+	switch {
+	case c.namespaces == nil:
+	case nd.ContainerName != "":
+		values = append(values, "", nd.ContainerName)
+	default:
+		values = append(values, nd.Namespace)
+	}
+	return values
+}
+
+// newDeviceScrape returns dev with the peers that pass the peer filters.
+func (c *Collector) newDeviceScrape(nd NamespacedDevice, ifaceValues []string) *deviceScrape {
+	d := &deviceScrape{dev: nd.Device, namespace: nd.Namespace, ifaceValues: ifaceValues}
+	for i := range d.dev.Peers {
+		if peer := &d.dev.Peers[i]; c.shouldExportPeer(peer) {
+			d.peers = append(d.peers, peer)
+		}
+	}
+	return d
+}
+
+// collectDevice emits the interface metrics of d and the per-peer metrics
+// of the peers kept by the peer limits. Interface aggregates count every
+// peer that passes the peer filters.
+func (c *Collector) collectDevice(s *scrape, d *deviceScrape) {
+	ch := s.ch
+	dev, ifaceValues := d.dev, d.ifaceValues
+
+	ch <- prometheus.MustNewConstMetric(
+		c.ifaceDescs.info, prometheus.GaugeValue, 1,
+		slices.Concat(ifaceValues, []string{dev.PublicKey.String(), strconv.Itoa(dev.ListenPort), deviceTypeLabel(dev.Type)})...,
+	)
+
+	var friendlyNames map[string]string
+	if c.wgQuick != nil {
+		friendlyNames = c.wgQuick.PeerNames(dev.Name)
+	}
+	ifaceKey := strings.Join(ifaceValues, "\xff")
+
+	var peersUp, peersNoHandshake int
+	for i, peer := range d.peers {
+		var up bool
+		if d.kept(i) {
+			labels := c.peerLabelsFor(s, ifaceKey, ifaceValues, peer, friendlyNames)
+			up = c.collectPeer(s, d, peer, labels)
+		} else {
+			up = c.isPeerUp(dev.Name, peer, s.now)
+		}
+		if up {
+			peersUp++
+		}
+		if peer.LastHandshakeTime.IsZero() {
+			peersNoHandshake++
+		}
+	}
+
+	ch <- prometheus.MustNewConstMetric(c.ifaceDescs.peers, prometheus.GaugeValue, float64(len(d.peers)), ifaceValues...)
+	ch <- prometheus.MustNewConstMetric(c.ifaceDescs.peersUp, prometheus.GaugeValue, float64(peersUp), ifaceValues...)
+	ch <- prometheus.MustNewConstMetric(c.ifaceDescs.peersNoHandshake, prometheus.GaugeValue, float64(peersNoHandshake), ifaceValues...)
+	ch <- prometheus.MustNewConstMetric(c.ifaceDescs.firewallMark, prometheus.GaugeValue, float64(dev.FirewallMark), ifaceValues...)
+	ch <- prometheus.MustNewConstMetric(c.ifaceDescs.peersTruncated, prometheus.GaugeValue, float64(d.truncated), ifaceValues...)
+}
+
+// collectPeer emits the metrics of a single peer and reports whether the
+// peer is up. Metrics share the peer's cached label pairs.
+func (c *Collector) collectPeer(s *scrape, d *deviceScrape, peer *wgtypes.Peer, labels *peerLabels) bool {
+	ch := s.ch
+	descs := s.descs
+	// All metrics of the peer share one allocation. The capacity covers
+	// every metric below, so appending never moves sent metrics.
+	batch := make([]cachedMetric, 0, maxPeerMetrics+len(labels.allowedIPInfo))
+	emit := func(desc *prometheus.Desc, typ prometheus.ValueType, value float64, pairs []*dto.LabelPair) {
+		batch = append(batch, cachedMetric{desc: desc, typ: typ, value: value, labels: pairs})
+		ch <- &batch[len(batch)-1]
+	}
+
+	emit(descs.handshake, prometheus.GaugeValue, float64(peer.LastHandshakeTime.Unix()), labels.pairs)
+	if !peer.LastHandshakeTime.IsZero() {
+		emit(descs.handshakeAge, prometheus.GaugeValue, max(s.now.Sub(peer.LastHandshakeTime).Seconds(), 0), labels.pairs)
+	} else if c.neverHandshake == NeverHandshakeInf {
+		emit(descs.handshakeAge, prometheus.GaugeValue, math.Inf(1), labels.pairs)
+	}
+	emit(descs.transmitTotal, prometheus.CounterValue, float64(peer.TransmitBytes), labels.pairs)
+	emit(descs.receiveTotal, prometheus.CounterValue, float64(peer.ReceiveBytes), labels.pairs)
+	if rate, ok := s.rates[labels.key]; ok {
+		emit(descs.transmitRate, prometheus.GaugeValue, rate.transmit, labels.pairs)
+		emit(descs.receiveRate, prometheus.GaugeValue, rate.receive, labels.pairs)
+	}
+	if totals, ok := s.totals[labels.key]; ok {
+		emit(descs.transmitCum, prometheus.CounterValue, float64(totals.transmit), labels.pairs)
+		emit(descs.receiveCum, prometheus.CounterValue, float64(totals.receive), labels.pairs)
+	}
+	if limit, used, ok := s.peerQuota(d, labels.pubKey); ok {
+		emit(descs.quota, prometheus.GaugeValue, float64(limit), labels.pairs)
+		emit(descs.quotaUsed, prometheus.GaugeValue, float64(used)/float64(limit), labels.pairs)
+	}
+	if session, ok := s.sessions[labels.key]; ok {
+		emit(descs.firstSeen, prometheus.GaugeValue, float64(session.firstSeen.Unix()), labels.pairs)
+		if !session.start.IsZero() {
+			emit(descs.sessionStart, prometheus.GaugeValue, float64(session.start.Unix()), labels.pairs)
+		}
+		emit(descs.sessions, prometheus.CounterValue, float64(session.count), labels.pairs)
+		emit(descs.connected, prometheus.CounterValue, session.connected.Seconds(), labels.pairs)
+	}
+	if c.legacyByteGauges {
+		emit(descs.transmit, prometheus.GaugeValue, float64(peer.TransmitBytes), labels.pairs)
+		emit(descs.received, prometheus.GaugeValue, float64(peer.ReceiveBytes), labels.pairs)
+	}
+
+	state := c.peerState(d.dev.Name, peer, s.now)
+	isUp := state == peerStateActive
+	up := 0.0
+	if isUp {
+		up = 1.0
+	}
+	emit(descs.peerUp, prometheus.GaugeValue, up, labels.pairs)
+
+	for i, st := range peerStates {
+		v := 0.0
+		if st == state {
+			v = 1.0
+		}
+		emit(descs.peerState, prometheus.GaugeValue, v, labels.states[i])
+	}
+
+	emit(descs.keepalive, prometheus.GaugeValue, peer.PersistentKeepaliveInterval.Seconds(), labels.pairs)
+	emit(descs.protocol, prometheus.GaugeValue, float64(peer.ProtocolVersion), labels.pairs)
+	emit(descs.allowedIPs, prometheus.GaugeValue, float64(len(peer.AllowedIPs)), labels.pairs)
+
+	for _, pairs := range labels.allowedIPInfo {
+		emit(descs.allowedIPInfo, prometheus.GaugeValue, 1, pairs)
+	}
+	if labels.endpointPairs != nil {
+		emit(descs.endpoint, prometheus.GaugeValue, 1, labels.endpointPairs)
+	}
+
+	return isUp
+}
+
+// deviceTypeLabel returns the device type as a label value such as
+// "linux_kernel" or "userspace".
+func deviceTypeLabel(t wgtypes.DeviceType) string {
+	return strings.ReplaceAll(strings.ToLower(t.String()), " ", "_")
+}
+
+// peerMetricLabels are the label names that individual peer metrics add
+// to the peer labels.
+var peerMetricLabels = []string{"prefix", "family", "endpoint_ip", "endpoint_port", "wireguard_peer_state"}
+
+// metadataLabelKeys returns the metadata label keys that do not clash with
+// a label the collector already sets. Clashing keys are skipped with a
+// warning, logged once per loaded metadata file.
+func (c *Collector) metadataLabelKeys(meta *peermeta.Metadata) []string {
+	static := c.staticPeerLabels()
+	var keys, reserved []string
+	for _, k := range meta.LabelKeys() {
+		if slices.Contains(static, k) || slices.Contains(peerMetricLabels, k) {
+			reserved = append(reserved, k)
+			continue
+		}
+		keys = append(keys, k)
+	}
+	if len(reserved) > 0 && c.warnedMeta.Swap(meta) != meta {
+		slog.Warn("ignoring peer metadata labels reserved by the exporter", "labels", reserved)
+	}
+	return keys
+}
+
+// appendMetadataValues appends the peer name and the values of keys for
+// the peer with the given public key. Unknown peers are named by key.
+func appendMetadataValues(values []string, meta *peermeta.Metadata, keys []string, pubKey string) []string {
+	peer, ok := meta.Lookup(pubKey)
+	name := peer.Name
+	if !ok || name == "" {
+		name = pubKey
+	}
+	values = append(values, name)
+	for _, k := range keys {
+		values = append(values, peer.Labels[k])
+	}
+	return values
+}
+
+// endpointLabelValues returns the IP, port and address family of addr.
+func endpointLabelValues(addr *net.UDPAddr) []string {
+	return []string{addr.IP.String(), strconv.Itoa(addr.Port), ipFamily(addr.IP)}
+}
+
+// ipFamily returns "ipv4" or "ipv6" for ip.
+func ipFamily(ip net.IP) string {
+	if ip.To4() != nil {
+		return "ipv4"
 	}
-	_, ok := c.monitorSet[name]
-	return ok
+	return "ipv6"
 }
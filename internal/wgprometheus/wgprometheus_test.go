@@ -2,12 +2,17 @@ package wgprometheus
 
 import (
 	"errors"
+	"math"
 	"net"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	dto "github.com/prometheus/client_model/go"
+	"github.com/sathiraumesh/wireguard_exporter/internal/peermeta"
+	"github.com/sathiraumesh/wireguard_exporter/internal/wgquick"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
@@ -34,7 +39,7 @@ func newTestPeer(pubKeyByte byte, transmit, received int64, handshake time.Time)
 	}
 }
 
-func collectMetrics(t *testing.T, c *Collector) []*dto.MetricFamily {
+func collectMetrics(t *testing.T, c prometheus.Collector) []*dto.MetricFamily {
 	t.Helper()
 	reg := prometheus.NewRegistry()
 	reg.MustRegister(c)
@@ -66,14 +71,20 @@ func TestCollectSpecifiedInterfaces(t *testing.T) {
 	families := collectMetrics(t, c)
 	fm := familyMap(families)
 
-	assert.Equal(t, 7, len(families))
+	assert.Equal(t, 22, len(families))
 
 	// Per-peer metrics should only contain wg0
 	for _, name := range []string{
 		"wireguard_latest_handshake_seconds",
+		"wireguard_peer_handshake_age_seconds",
 		"wireguard_transmitted_bytes",
 		"wireguard_received_bytes",
+		"wireguard_peer_transmit_bytes_total",
+		"wireguard_peer_receive_bytes_total",
 		"wireguard_peer_up",
+		"wireguard_peer_persistent_keepalive_seconds",
+		"wireguard_peer_protocol_version",
+		"wireguard_peer_allowed_ips_count",
 	} {
 		require.Contains(t, fm, name)
 		assert.Equal(t, 1, len(fm[name].GetMetric()))
@@ -110,14 +121,20 @@ func TestCollectAllInterfaces(t *testing.T) {
 	families := collectMetrics(t, c)
 	fm := familyMap(families)
 
-	assert.Equal(t, 7, len(families))
+	assert.Equal(t, 22, len(families))
 
 	// Per-peer metrics should have 2 entries (one per device/peer)
 	for _, name := range []string{
 		"wireguard_latest_handshake_seconds",
+		"wireguard_peer_handshake_age_seconds",
 		"wireguard_transmitted_bytes",
 		"wireguard_received_bytes",
+		"wireguard_peer_transmit_bytes_total",
+		"wireguard_peer_receive_bytes_total",
 		"wireguard_peer_up",
+		"wireguard_peer_persistent_keepalive_seconds",
+		"wireguard_peer_protocol_version",
+		"wireguard_peer_allowed_ips_count",
 	} {
 		require.Contains(t, fm, name)
 		assert.Equal(t, 2, len(fm[name].GetMetric()))
@@ -142,7 +159,7 @@ func TestCollectDeviceError(t *testing.T) {
 	fm := familyMap(families)
 
 	// Only scrape metrics emitted on error
-	assert.Equal(t, 2, len(families))
+	assert.Equal(t, 3, len(families))
 	require.Contains(t, fm, "wireguard_scrape_success")
 	assert.Equal(t, 0.0, fm["wireguard_scrape_success"].GetMetric()[0].GetGauge().GetValue())
 }
@@ -163,6 +180,10 @@ func TestCollectMetricValues(t *testing.T) {
 	values := make(map[string]float64)
 	for _, family := range families {
 		for _, metric := range family.GetMetric() {
+			if family.GetType() == dto.MetricType_COUNTER {
+				values[family.GetName()] = metric.GetCounter().GetValue()
+				continue
+			}
 			values[family.GetName()] = metric.GetGauge().GetValue()
 		}
 	}
@@ -170,6 +191,8 @@ func TestCollectMetricValues(t *testing.T) {
 	assert.Equal(t, float64(1700000000), values["wireguard_latest_handshake_seconds"])
 	assert.Equal(t, float64(500), values["wireguard_transmitted_bytes"])
 	assert.Equal(t, float64(1000), values["wireguard_received_bytes"])
+	assert.Equal(t, float64(500), values["wireguard_peer_transmit_bytes_total"])
+	assert.Equal(t, float64(1000), values["wireguard_peer_receive_bytes_total"])
 	assert.Equal(t, 0.0, values["wireguard_peer_up"]) // handshake is far in the past
 	assert.Equal(t, 1.0, values["wireguard_interface_info"])
 	assert.Equal(t, 1.0, values["wireguard_scrape_success"])
@@ -192,3 +215,301 @@ func TestPeerUpWithRecentHandshake(t *testing.T) {
 	require.Contains(t, fm, "wireguard_peer_up")
 	assert.Equal(t, 1.0, fm["wireguard_peer_up"].GetMetric()[0].GetGauge().GetValue())
 }
+
+func labelMap(metric *dto.Metric) map[string]string {
+	m := make(map[string]string, len(metric.GetLabel()))
+	for _, label := range metric.GetLabel() {
+		m[label.GetName()] = label.GetValue()
+	}
+	return m
+}
+
+func TestCollectWithPeerMetadata(t *testing.T) {
+	peer1 := newTestPeer(1, 100, 200, time.Unix(1000, 0))
+	peer2 := newTestPeer(2, 300, 400, time.Unix(2000, 0))
+
+	path := filepath.Join(t.TempDir(), "peers.yml")
+	content := "peers:\n  \"" + peer1.PublicKey.String() + "\":\n    name: alice-laptop\n    labels:\n      team: infra\n      interface: ignored\n"
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	src, err := peermeta.NewSource(path)
+	require.NoError(t, err)
+
+	mock := &mockDeviceLister{
+		devices: []*wgtypes.Device{
+			{Name: "wg0", Peers: []wgtypes.Peer{peer1, peer2}},
+		},
+	}
+
+	c := NewCollectorWithDevices(nil, mock, WithPeerMetadata(src))
+	fm := familyMap(collectMetrics(t, c))
+
+	require.Contains(t, fm, "wireguard_peer_up")
+	byKey := make(map[string]map[string]string)
+	for _, metric := range fm["wireguard_peer_up"].GetMetric() {
+		labels := labelMap(metric)
+		byKey[labels["public_key"]] = labels
+	}
+
+	known := byKey[peer1.PublicKey.String()]
+	assert.Equal(t, "alice-laptop", known["name"])
+	assert.Equal(t, "infra", known["team"])
+	assert.Equal(t, "wg0", known["interface"])
+
+	unknown := byKey[peer2.PublicKey.String()]
+	assert.Equal(t, peer2.PublicKey.String(), unknown["name"])
+	assert.Equal(t, "", unknown["team"])
+}
+
+func TestCollectSkipsReservedMetadataLabels(t *testing.T) {
+	peer := newTestPeer(1, 100, 200, time.Unix(1000, 0))
+	peer.Endpoint = &net.UDPAddr{IP: net.IPv4(203, 0, 113, 7), Port: 51820}
+
+	path := filepath.Join(t.TempDir(), "peers.yml")
+	content := "peers:\n  \"" + peer.PublicKey.String() + "\":\n    labels:\n      team: infra\n      family: smith\n      prefix: x\n      endpoint_ip: x\n      endpoint_port: x\n      wireguard_peer_state: x\n"
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	src, err := peermeta.NewSource(path)
+	require.NoError(t, err)
+
+	mock := &mockDeviceLister{devices: []*wgtypes.Device{{Name: "wg0", Peers: []wgtypes.Peer{peer}}}}
+	c := NewCollectorWithDevices(nil, mock, WithPeerMetadata(src), WithAllowedIPsMode(AllowedIPsInfo))
+	fm := familyMap(collectMetrics(t, c))
+
+	endpoint := labelMap(fm["wireguard_peer_endpoint_info"].GetMetric()[0])
+	assert.Equal(t, "ipv4", endpoint["family"])
+	assert.Equal(t, "203.0.113.7", endpoint["endpoint_ip"])
+	assert.Equal(t, "infra", endpoint["team"])
+	prefix := labelMap(fm["wireguard_peer_allowed_ip_info"].GetMetric()[0])
+	assert.Equal(t, "10.0.0.1/32", prefix["prefix"])
+	assert.Equal(t, "ipv4", prefix["family"])
+	assert.Len(t, fm["wireguard_peer_state"].GetMetric(), len(peerStates))
+}
+
+func TestCollectWithWGQuickNames(t *testing.T) {
+	peer1 := newTestPeer(1, 100, 200, time.Unix(1000, 0))
+	peer2 := newTestPeer(2, 300, 400, time.Unix(2000, 0))
+
+	dir := t.TempDir()
+	conf := "[Interface]\nListenPort = 51820\n\n# Name = alice-laptop\n[Peer]\nPublicKey = " + peer1.PublicKey.String() + "\n"
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "wg0.conf"), []byte(conf), 0o600))
+
+	mock := &mockDeviceLister{
+		devices: []*wgtypes.Device{
+			{Name: "wg0", Peers: []wgtypes.Peer{peer1, peer2}},
+		},
+	}
+
+	c := NewCollectorWithDevices(nil, mock, WithWGQuickNames(wgquick.NewDir(dir)))
+	fm := familyMap(collectMetrics(t, c))
+
+	require.Contains(t, fm, "wireguard_transmitted_bytes")
+	names := make(map[string]string)
+	for _, metric := range fm["wireguard_transmitted_bytes"].GetMetric() {
+		labels := labelMap(metric)
+		names[labels["public_key"]] = labels["friendly_name"]
+	}
+	assert.Equal(t, "alice-laptop", names[peer1.PublicKey.String()])
+	assert.Equal(t, "", names[peer2.PublicKey.String()])
+}
+
+func TestCollectPeerEndpointInfo(t *testing.T) {
+	peer1 := newTestPeer(1, 100, 200, time.Unix(1000, 0))
+	peer1.Endpoint = &net.UDPAddr{IP: net.IPv4(203, 0, 113, 7), Port: 51820}
+	peer2 := newTestPeer(2, 300, 400, time.Unix(2000, 0))
+	peer2.Endpoint = &net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 4500}
+	peer3 := newTestPeer(3, 0, 0, time.Time{})
+
+	mock := &mockDeviceLister{
+		devices: []*wgtypes.Device{
+			{Name: "wg0", Peers: []wgtypes.Peer{peer1, peer2, peer3}},
+		},
+	}
+
+	c := NewCollectorWithDevices(nil, mock)
+	fm := familyMap(collectMetrics(t, c))
+
+	require.Contains(t, fm, "wireguard_peer_endpoint_info")
+	metrics := fm["wireguard_peer_endpoint_info"].GetMetric()
+	require.Equal(t, 2, len(metrics))
+
+	byKey := make(map[string]map[string]string)
+	for _, metric := range metrics {
+		assert.Equal(t, 1.0, metric.GetGauge().GetValue())
+		labels := labelMap(metric)
+		byKey[labels["public_key"]] = labels
+	}
+
+	v4 := byKey[peer1.PublicKey.String()]
+	assert.Equal(t, "203.0.113.7", v4["endpoint_ip"])
+	assert.Equal(t, "51820", v4["endpoint_port"])
+	assert.Equal(t, "ipv4", v4["family"])
+
+	v6 := byKey[peer2.PublicKey.String()]
+	assert.Equal(t, "2001:db8::1", v6["endpoint_ip"])
+	assert.Equal(t, "4500", v6["endpoint_port"])
+	assert.Equal(t, "ipv6", v6["family"])
+
+	// Endpoint labels stay off the other peer metrics
+	for _, metric := range fm["wireguard_transmitted_bytes"].GetMetric() {
+		assert.NotContains(t, labelMap(metric), "endpoint_ip")
+	}
+}
+
+func TestCollectWithoutLegacyByteGauges(t *testing.T) {
+	peer := newTestPeer(1, 500, 1000, time.Unix(1000, 0))
+
+	mock := &mockDeviceLister{
+		devices: []*wgtypes.Device{
+			{Name: "wg0", Peers: []wgtypes.Peer{peer}},
+		},
+	}
+
+	c := NewCollectorWithDevices(nil, mock, WithLegacyByteGauges(false))
+	fm := familyMap(collectMetrics(t, c))
+
+	assert.NotContains(t, fm, "wireguard_transmitted_bytes")
+	assert.NotContains(t, fm, "wireguard_received_bytes")
+
+	require.Contains(t, fm, "wireguard_peer_transmit_bytes_total")
+	assert.Equal(t, dto.MetricType_COUNTER, fm["wireguard_peer_transmit_bytes_total"].GetType())
+	assert.Equal(t, 500.0, fm["wireguard_peer_transmit_bytes_total"].GetMetric()[0].GetCounter().GetValue())
+	require.Contains(t, fm, "wireguard_peer_receive_bytes_total")
+	assert.Equal(t, dto.MetricType_COUNTER, fm["wireguard_peer_receive_bytes_total"].GetType())
+	assert.Equal(t, 1000.0, fm["wireguard_peer_receive_bytes_total"].GetMetric()[0].GetCounter().GetValue())
+}
+
+func TestCollectPeerConfigMetrics(t *testing.T) {
+	peer := newTestPeer(1, 100, 200, time.Unix(1000, 0))
+	peer.PersistentKeepaliveInterval = 25 * time.Second
+	peer.ProtocolVersion = 1
+	peer.AllowedIPs = append(peer.AllowedIPs, net.IPNet{IP: net.ParseIP("fd00::"), Mask: net.CIDRMask(64, 128)})
+
+	mock := &mockDeviceLister{
+		devices: []*wgtypes.Device{
+			{Name: "wg0", Peers: []wgtypes.Peer{peer}},
+		},
+	}
+
+	c := NewCollectorWithDevices(nil, mock)
+	fm := familyMap(collectMetrics(t, c))
+
+	for name, expected := range map[string]float64{
+		"wireguard_peer_persistent_keepalive_seconds": 25,
+		"wireguard_peer_protocol_version":             1,
+		"wireguard_peer_allowed_ips_count":            2,
+	} {
+		require.Contains(t, fm, name)
+		assert.Equal(t, expected, fm[name].GetMetric()[0].GetGauge().GetValue(), name)
+	}
+}
+
+func TestCollectInterfaceMetrics(t *testing.T) {
+	upPeer := newTestPeer(1, 100, 200, time.Now().Add(-time.Minute))
+	stalePeer := newTestPeer(2, 100, 200, time.Unix(1000, 0))
+	newPeer := newTestPeer(3, 0, 0, time.Time{})
+
+	mock := &mockDeviceLister{
+		devices: []*wgtypes.Device{
+			{
+				Name:         "wg0",
+				Type:         wgtypes.LinuxKernel,
+				FirewallMark: 42,
+				Peers:        []wgtypes.Peer{upPeer, stalePeer, newPeer},
+			},
+		},
+	}
+
+	c := NewCollectorWithDevices(nil, mock)
+	fm := familyMap(collectMetrics(t, c))
+
+	for name, expected := range map[string]float64{
+		"wireguard_interface_peers":                  3,
+		"wireguard_interface_peers_up":               1,
+		"wireguard_interface_peers_never_handshaked": 1,
+		"wireguard_interface_firewall_mark":          42,
+	} {
+		require.Contains(t, fm, name)
+		metric := fm[name].GetMetric()[0]
+		assert.Equal(t, expected, metric.GetGauge().GetValue(), name)
+		assert.Equal(t, "wg0", labelMap(metric)["interface"], name)
+	}
+
+	require.Contains(t, fm, "wireguard_interface_info")
+	assert.Equal(t, "linux_kernel", labelMap(fm["wireguard_interface_info"].GetMetric()[0])["device_type"])
+}
+
+func TestCollectAllowedIPsInfoMode(t *testing.T) {
+	peer := newTestPeer(1, 100, 200, time.Unix(1000, 0))
+	peer.AllowedIPs = append(peer.AllowedIPs, net.IPNet{IP: net.ParseIP("fd00::"), Mask: net.CIDRMask(64, 128)})
+
+	mock := &mockDeviceLister{
+		devices: []*wgtypes.Device{
+			{Name: "wg0", Peers: []wgtypes.Peer{peer}},
+		},
+	}
+
+	c := NewCollectorWithDevices(nil, mock, WithAllowedIPsMode(AllowedIPsInfo))
+	fm := familyMap(collectMetrics(t, c))
+
+	for _, metric := range fm["wireguard_peer_up"].GetMetric() {
+		assert.NotContains(t, labelMap(metric), "allowed_ips")
+	}
+
+	require.Contains(t, fm, "wireguard_peer_allowed_ip_info")
+	families := make(map[string]string)
+	for _, metric := range fm["wireguard_peer_allowed_ip_info"].GetMetric() {
+		labels := labelMap(metric)
+		assert.Equal(t, peer.PublicKey.String(), labels["public_key"])
+		families[labels["prefix"]] = labels["family"]
+	}
+	assert.Equal(t, map[string]string{"10.0.0.1/32": "ipv4", "fd00::/64": "ipv6"}, families)
+}
+
+func TestCollectAllowedIPsLabelMode(t *testing.T) {
+	peer := newTestPeer(1, 100, 200, time.Unix(1000, 0))
+
+	mock := &mockDeviceLister{
+		devices: []*wgtypes.Device{
+			{Name: "wg0", Peers: []wgtypes.Peer{peer}},
+		},
+	}
+
+	c := NewCollectorWithDevices(nil, mock)
+	fm := familyMap(collectMetrics(t, c))
+
+	assert.NotContains(t, fm, "wireguard_peer_allowed_ip_info")
+	require.Contains(t, fm, "wireguard_peer_up")
+	assert.Equal(t, "[{10.0.0.1 ffffffff}]", labelMap(fm["wireguard_peer_up"].GetMetric()[0])["allowed_ips"])
+}
+
+func TestCollectHandshakeAge(t *testing.T) {
+	recent := newTestPeer(1, 100, 200, time.Now().Add(-90*time.Second))
+	never := newTestPeer(2, 0, 0, time.Time{})
+
+	mock := &mockDeviceLister{
+		devices: []*wgtypes.Device{
+			{Name: "wg0", Peers: []wgtypes.Peer{recent, never}},
+		},
+	}
+
+	t.Run("omits peers without handshake by default", func(t *testing.T) {
+		fm := familyMap(collectMetrics(t, NewCollectorWithDevices(nil, mock)))
+
+		require.Contains(t, fm, "wireguard_peer_handshake_age_seconds")
+		metrics := fm["wireguard_peer_handshake_age_seconds"].GetMetric()
+		require.Equal(t, 1, len(metrics))
+		assert.Equal(t, recent.PublicKey.String(), labelMap(metrics[0])["public_key"])
+		assert.InDelta(t, 90, metrics[0].GetGauge().GetValue(), 5)
+	})
+
+	t.Run("reports +Inf for peers without handshake", func(t *testing.T) {
+		c := NewCollectorWithDevices(nil, mock, WithNeverHandshakeMode(NeverHandshakeInf))
+		fm := familyMap(collectMetrics(t, c))
+
+		ages := make(map[string]float64)
+		for _, metric := range fm["wireguard_peer_handshake_age_seconds"].GetMetric() {
+			ages[labelMap(metric)["public_key"]] = metric.GetGauge().GetValue()
+		}
+		require.Equal(t, 2, len(ages))
+		assert.True(t, math.IsInf(ages[never.PublicKey.String()], 1))
+	})
+}
@@ -0,0 +1,158 @@
+// Package wgquick extracts peer annotations from wg-quick configuration
+// files, such as "# Name = alice-laptop" comments above [Peer] sections.
+package wgquick
+
+import (
+	"bufio"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Peer is a [Peer] section of a wg-quick config.
+type Peer struct {
+	PublicKey string
+	Name      string
+}
+
+// Parse reads a wg-quick config and returns its peers in file order.
+//
+// A "# Name = value" comment names the next [Peer] section when it appears
+// above the section header, or the current one when it appears inside the
+// section before any other name comment.
+func Parse(r io.Reader) ([]Peer, error) {
+	var peers []Peer
+	var current *Peer
+	var pending string
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "":
+			continue
+
+		case strings.HasPrefix(line, "#"):
+			if name, ok := nameComment(line); ok {
+				pending = name
+			}
+
+		case strings.HasPrefix(line, "["):
+			current = nil
+			if strings.EqualFold(line, "[Peer]") {
+				peers = append(peers, Peer{Name: pending})
+				current = &peers[len(peers)-1]
+			}
+			pending = ""
+
+		default:
+			if current == nil {
+				continue
+			}
+			if pending != "" && current.Name == "" {
+				current.Name = pending
+				pending = ""
+			}
+			key, value, ok := strings.Cut(line, "=")
+			if ok && strings.EqualFold(strings.TrimSpace(key), "PublicKey") {
+				current.PublicKey = strings.TrimSpace(stripComment(value))
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return peers, nil
+}
+
+// nameComment reports the value of a "# Name = value" comment line.
+func nameComment(line string) (string, bool) {
+	key, value, ok := strings.Cut(strings.TrimLeft(line, "# \t"), "=")
+	if !ok || !strings.EqualFold(strings.TrimSpace(key), "Name") {
+		return "", false
+	}
+	value = strings.TrimSpace(value)
+	return value, value != ""
+}
+
+func stripComment(value string) string {
+	if i := strings.Index(value, "#"); i >= 0 {
+		return value[:i]
+	}
+	return value
+}
+
+// PeerNames maps public keys to names for the peers that have one.
+func PeerNames(peers []Peer) map[string]string {
+	names := make(map[string]string, len(peers))
+	for _, p := range peers {
+		if p.PublicKey != "" && p.Name != "" {
+			names[p.PublicKey] = p.Name
+		}
+	}
+	return names
+}
+
+type cachedFile struct {
+	modTime time.Time
+	size    int64
+	names   map[string]string
+}
+
+// Dir reads <dir>/<interface>.conf files on demand and caches the parsed
+// peer names until the file changes.
+type Dir struct {
+	path string
+
+	mu    sync.Mutex
+	files map[string]cachedFile
+}
+
+// NewDir returns a Dir reading configs from path, typically /etc/wireguard.
+func NewDir(path string) *Dir {
+	return &Dir{path: path, files: make(map[string]cachedFile)}
+}
+
+// PeerNames returns the peer names annotated in the config of the given
+// interface. A missing or unreadable config yields no names.
+func (d *Dir) PeerNames(iface string) map[string]string {
+	file := filepath.Join(d.path, iface+".conf")
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	info, err := os.Stat(file)
+	if err != nil {
+		delete(d.files, iface)
+		if !os.IsNotExist(err) {
+			slog.Warn("failed to stat wg-quick config", "path", file, "error", err)
+		}
+		return nil
+	}
+
+	cached, ok := d.files[iface]
+	if ok && cached.modTime.Equal(info.ModTime()) && cached.size == info.Size() {
+		return cached.names
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		slog.Warn("failed to open wg-quick config", "path", file, "error", err)
+		return cached.names
+	}
+	defer f.Close()
+
+	peers, err := Parse(f)
+	if err != nil {
+		slog.Warn("failed to parse wg-quick config", "path", file, "error", err)
+		return cached.names
+	}
+
+	names := PeerNames(peers)
+	d.files[iface] = cachedFile{modTime: info.ModTime(), size: info.Size(), names: names}
+	return names
+}
@@ -0,0 +1,88 @@
+package wgquick
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func parseFile(t *testing.T, path string) []Peer {
+	t.Helper()
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	peers, err := Parse(f)
+	require.NoError(t, err)
+	return peers
+}
+
+func TestParseSetupConfigs(t *testing.T) {
+	tests := []struct {
+		file     string
+		expected []Peer
+	}{
+		{
+			file:     "wg0_host_1.conf",
+			expected: []Peer{{PublicKey: "YexUX3CRfPHSt7DYKV5gnRJWd8hDNkE2QxHIMZa5eEg=", Name: "host-2"}},
+		},
+		{
+			file:     "wg0_host_2.conf",
+			expected: []Peer{{PublicKey: "HYf+yNzgj3uhARFlNy3Pawuk/yLC+WYoY2qwjjlSxxI=", Name: "host-1"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			peers := parseFile(t, filepath.Join("..", "..", "setup", "wireguard", tt.file))
+			assert.Equal(t, tt.expected, peers)
+		})
+	}
+}
+
+func TestParseHubConfig(t *testing.T) {
+	peers := parseFile(t, filepath.Join("testdata", "hub.conf"))
+
+	assert.Equal(t, []Peer{
+		{PublicKey: "YexUX3CRfPHSt7DYKV5gnRJWd8hDNkE2QxHIMZa5eEg=", Name: "alice-laptop"},
+		{PublicKey: "HYf+yNzgj3uhARFlNy3Pawuk/yLC+WYoY2qwjjlSxxI=", Name: "ams-site"},
+		{PublicKey: "8KfBZXpTg6cGwAhMuw9BRm5dXJ1ZJqNdRm9o7mXl+0U="},
+	}, peers)
+
+	assert.Equal(t, map[string]string{
+		"YexUX3CRfPHSt7DYKV5gnRJWd8hDNkE2QxHIMZa5eEg=": "alice-laptop",
+		"HYf+yNzgj3uhARFlNy3Pawuk/yLC+WYoY2qwjjlSxxI=": "ams-site",
+	}, PeerNames(peers))
+}
+
+func TestParseNameAfterPreviousPeer(t *testing.T) {
+	conf := `[Peer]
+PublicKey = A
+# Name = second
+[Peer]
+PublicKey = B
+`
+	peers, err := Parse(strings.NewReader(conf))
+	require.NoError(t, err)
+	assert.Equal(t, []Peer{{PublicKey: "A"}, {PublicKey: "B", Name: "second"}}, peers)
+}
+
+func TestDirPeerNames(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "wg0.conf")
+	mtime := time.Now().Add(-time.Hour)
+	require.NoError(t, os.WriteFile(path, []byte("# Name = alice\n[Peer]\nPublicKey = A\n"), 0o600))
+	require.NoError(t, os.Chtimes(path, mtime, mtime))
+
+	d := NewDir(dir)
+	assert.Equal(t, map[string]string{"A": "alice"}, d.PeerNames("wg0"))
+	assert.Nil(t, d.PeerNames("wg1"))
+
+	require.NoError(t, os.WriteFile(path, []byte("# Name = bob\n[Peer]\nPublicKey = A\n"), 0o600))
+	require.NoError(t, os.Chtimes(path, mtime.Add(time.Minute), mtime.Add(time.Minute)))
+	assert.Equal(t, map[string]string{"A": "bob"}, d.PeerNames("wg0"))
+}